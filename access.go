@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// accessLogInterval is the minimum interval between two log entries about rejected
+// sources of the same listener
+const accessLogInterval = 10 * time.Second
+
+// accessFilter checks the remote addresses of a listener against its allow and deny
+// lists. It counts the rejected sources and rate-limits the log entries about them.
+type accessFilter struct {
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+	logs     logLimiter
+	rejected atomic.Uint64
+}
+
+// filterListener wraps the net.Listener of an HTTP(S) listener and closes connections
+// from sources that are not allowed right after they have been accepted.
+type filterListener struct {
+	net.Listener
+	instance *listenerInstance
+	server   *Server
+}
+
+// newAccessFilter returns a new accessFilter for the given allow and deny lists in CIDR
+// notation
+func newAccessFilter(allow, deny []string) (*accessFilter, error) {
+	allowNets, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &accessFilter{
+		allow: allowNets,
+		deny:  denyNets,
+		logs:  logLimiter{interval: accessLogInterval},
+	}, nil
+}
+
+// Allowed returns true if the given remote address is allowed to send messages to the
+// listener. Addresses that are part of the deny list are always rejected. If an allow
+// list is configured, only addresses that are part of it are allowed. Addresses
+// without an IP address (e.g. of UNIX sockets) are always allowed.
+func (f *accessFilter) Allowed(addr net.Addr) bool {
+	if remoteIP(addr) == "" {
+		return true
+	}
+	if addrInNetworks(addr, f.deny) {
+		return false
+	}
+	return len(f.allow) == 0 || addrInNetworks(addr, f.allow)
+}
+
+// checkAccess returns true if the given remote address is allowed to send messages to
+// the given listener. Rejected sources are counted and logged, at most once per
+// accessLogInterval.
+func (s *Server) checkAccess(instance *listenerInstance, addr net.Addr) bool {
+	if instance.access.Allowed(addr) {
+		return true
+	}
+	instance.access.rejected.Add(1)
+	if shouldLog, suppressed := instance.access.logs.Allow(); shouldLog {
+		s.log.Warn("rejected message source not allowed by listener",
+			slog.String("listener", instance.conf.Name),
+			slog.String("remote_addr", addrString(addr)),
+			slog.Uint64("rejected_total", instance.access.rejected.Load()),
+			slog.Uint64("suppressed", suppressed))
+	}
+	return false
+}
+
+// Accept waits for and returns the next connection from an allowed source. Connections
+// from sources that are not allowed are closed.
+func (l *filterListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.server.checkAccess(l.instance, conn.RemoteAddr()) {
+			return conn, nil
+		}
+		_ = conn.Close()
+	}
+}
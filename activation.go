@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// EnvListenPID is the environment variable in which systemd passes the PID of the
+	// process the sockets are meant for
+	EnvListenPID = "LISTEN_PID"
+	// EnvListenFDs is the environment variable in which systemd passes the number of
+	// sockets passed to the process
+	EnvListenFDs = "LISTEN_FDS"
+	// EnvListenFDNames is the environment variable in which systemd passes the colon
+	// separated names of the sockets (FileDescriptorName= in the socket unit)
+	EnvListenFDNames = "LISTEN_FDNAMES"
+)
+
+const (
+	// listenFDsStart is the first file descriptor passed by systemd
+	listenFDsStart = 3
+	// listenFDNameUnknown is the name systemd uses for sockets without a configured name
+	listenFDNameUnknown = "unknown"
+)
+
+// ActivatedSockets returns the sockets that have been passed to the process via
+// systemd socket activation as a map of socket names to files. The sockets are
+// matched to the configured listeners by their name, so the FileDescriptorName= of
+// a socket unit must match the name of the listener. If no sockets have been passed
+// to the process, an empty map is returned.
+// The environment variables used for socket activation are unset afterwards, so
+// that they are not inherited by child processes.
+func ActivatedSockets() (map[string]*os.File, error) {
+	sockets := make(map[string]*os.File)
+	defer func() {
+		_ = os.Unsetenv(EnvListenPID)
+		_ = os.Unsetenv(EnvListenFDs)
+		_ = os.Unsetenv(EnvListenFDNames)
+	}()
+
+	listenPID := os.Getenv(EnvListenPID)
+	if listenPID == "" {
+		return sockets, nil
+	}
+	pid, err := strconv.Atoi(listenPID)
+	if err != nil {
+		return sockets, fmt.Errorf("invalid %s: %w", EnvListenPID, err)
+	}
+	if pid != os.Getpid() {
+		return sockets, nil
+	}
+	fdCount, err := strconv.Atoi(os.Getenv(EnvListenFDs))
+	if err != nil || fdCount < 0 {
+		return sockets, fmt.Errorf("invalid %s: %q", EnvListenFDs, os.Getenv(EnvListenFDs))
+	}
+
+	var names []string
+	if fdNames := os.Getenv(EnvListenFDNames); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+	for i := 0; i < fdCount; i++ {
+		name := listenFDNameUnknown
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		if _, ok := sockets[name]; ok {
+			return sockets, fmt.Errorf("duplicate socket name passed by systemd: %s", name)
+		}
+		sockets[name] = os.NewFile(uintptr(listenFDsStart+i), name)
+	}
+	return sockets, nil
+}
@@ -5,11 +5,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/wneessen/logranger"
 )
@@ -17,9 +21,17 @@ import (
 const (
 	// LogErrKey is the keyword used in slog for error messages
 	LogErrKey = "error"
+	// ShutdownTimeout is the maximum duration for draining connections and actions
+	// when the server is shut down
+	ShutdownTimeout = 30 * time.Second
 )
 
 func main() {
+	stdinMode := flag.Bool("stdin", false, "process log messages from standard input until EOF and exit")
+	maxParseErrors := flag.Uint("max-parse-errors", 0,
+		"maximum number of parse errors in stdin mode before exiting with a non-zero status")
+	flag.Parse()
+
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil)).With(slog.String("context", "logranger"))
 	confPath := "logranger.toml"
 	confPathEnv := os.Getenv("LOGRANGER_CONFIG")
@@ -41,8 +53,35 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *stdinMode {
+		stats, err := server.ProcessReader(os.Stdin)
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		shutdownErr := server.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			logger.Error("failed to process standard input", LogErrKey, err)
+			os.Exit(1)
+		}
+		if shutdownErr != nil {
+			logger.Error("failed to shut down server gracefully", LogErrKey, shutdownErr)
+			os.Exit(1)
+		}
+		logger.Info("finished processing standard input", slog.Uint64("messages", uint64(stats.Messages)),
+			slog.Uint64("parse_errors", uint64(stats.ParseErrors)))
+		if stats.ParseErrors > *maxParseErrors {
+			logger.Error("number of parse errors exceeds threshold",
+				slog.Uint64("parse_errors", uint64(stats.ParseErrors)),
+				slog.Uint64("max_parse_errors", uint64(*maxParseErrors)))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	go func() {
-		if err = server.Run(); err != nil {
+		if err := server.Run(); err != nil {
+			if errors.Is(err, logranger.ErrServerShutdown) {
+				return
+			}
 			logger.Error("failed to start logranger", LogErrKey, err)
 			os.Exit(1)
 		}
@@ -53,7 +92,13 @@ func main() {
 	for recvSig := range signalChan {
 		if recvSig == syscall.SIGKILL || recvSig == syscall.SIGABRT || recvSig == syscall.SIGINT || recvSig == syscall.SIGTERM {
 			logger.Warn("received signal. shutting down server", slog.String("signal", recvSig.String()))
-			// server.Stop()
+			ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+			shutdownErr := server.Shutdown(ctx)
+			cancel()
+			if shutdownErr != nil {
+				logger.Error("failed to shut down server gracefully", LogErrKey, shutdownErr)
+				os.Exit(1)
+			}
 			logger.Info("server gracefully shut down")
 			os.Exit(0)
 		}
@@ -7,6 +7,7 @@ package logranger
 import (
 	"fmt"
 	"os"
+	"runtime"
 	"strings"
 	"time"
 
@@ -22,34 +23,170 @@ type Config struct {
 	Server struct {
 		PIDFile  string `fig:"pid_file" default:"/var/run/logranger.pid"`
 		RuleFile string `fig:"rule_file" default:"etc/logranger.rules.toml"`
+		// FlushInterval is the interval in which actions that implement the
+		// plugins.Lifecycle interface are flushed. A negative value disables it.
+		FlushInterval time.Duration `fig:"flush_interval" default:"10s"`
 	}
 	Listener struct {
 		ListenerUnix struct {
 			Path string `fig:"path" default:"/var/tmp/logranger.sock"`
 		} `fig:"unix"`
 		ListenerTCP struct {
-			Addr string `fig:"addr" default:"0.0.0.0"`
-			Port uint   `fig:"port" default:"9099"`
+			Addr    string  `fig:"addr" default:"0.0.0.0"`
+			Port    uint    `fig:"port" default:"9099"`
+			Framing Framing `fig:"framing" default:"auto"`
 		} `fig:"tcp"`
 		ListenerTLS struct {
-			Addr     string `fig:"addr" default:"0.0.0.0"`
-			Port     uint   `fig:"port" default:"9099"`
-			CertPath string `fig:"cert_path"`
-			KeyPath  string `fig:"key_path"`
+			Addr               string        `fig:"addr" default:"0.0.0.0"`
+			Port               uint          `fig:"port" default:"9099"`
+			CertPath           string        `fig:"cert_path"`
+			KeyPath            string        `fig:"key_path"`
+			ClientCAPath       string        `fig:"client_ca_path"`
+			ClientAuth         TLSClientAuth `fig:"client_auth"`
+			ClientAllow        []string      `fig:"client_allow"`
+			Framing            Framing       `fig:"framing" default:"auto"`
+			MinVersion         string        `fig:"min_version" default:"1.2"`
+			CipherSuites       []string      `fig:"cipher_suites"`
+			CurvePreferences   []string      `fig:"curve_preferences"`
+			CertReloadInterval time.Duration `fig:"cert_reload_interval" default:"30s"`
 		} `fig:"tls"`
+		ListenerUDP struct {
+			Addr string `fig:"addr" default:"0.0.0.0"`
+			Port uint   `fig:"port" default:"9099"`
+		} `fig:"udp"`
 		Type ListenerType `fig:"type" default:"unix"`
 	} `fig:"listener"`
-	Log struct {
+	// Listeners holds the configuration of multiple concurrent listeners. If no
+	// listeners are configured, the single listener of the Listener block is used.
+	Listeners []ListenerConfig `fig:"listeners"`
+	// FileInputs holds the configuration of the file-tailing inputs
+	FileInputs []FileInputConfig `fig:"file_inputs"`
+	Log        struct {
 		Level    string `fig:"level" default:"info"`
 		Extended bool   `fig:"extended"`
 	} `fig:"log"`
 	Parser struct {
-		Type    string        `fig:"type" validate:"required"`
+		Type    string        `fig:"type"`
 		Timeout time.Duration `fig:"timeout" default:"500ms"`
 	} `fig:"parser"`
-	internal struct {
-		ParserType parsesyslog.ParserType
-	}
+	// Queue configures the processing queue between the inputs and the workers that
+	// match the messages against the ruleset. If Workers is not set, one worker per
+	// CPU is started.
+	Queue struct {
+		Workers int         `fig:"workers"`
+		Size    int         `fig:"size" default:"10000"`
+		Policy  QueuePolicy `fig:"policy" default:"block"`
+	} `fig:"queue"`
+	// Spool configures the optional on-disk spool. If enabled, every accepted message
+	// is written to the spool before it is queued and removed once all actions have
+	// processed it successfully. Messages that remain in the spool are replayed on
+	// the next start.
+	Spool struct {
+		Enabled     bool   `fig:"enabled"`
+		Path        string `fig:"path" default:"/var/tmp/logranger-spool"`
+		SegmentSize int64  `fig:"segment_size" default:"16777216"`
+		Sync        bool   `fig:"sync"`
+	} `fig:"spool"`
+}
+
+// ListenerConfig holds the configuration settings of a single listener. Depending
+// on the listener type, only a subset of the settings is used.
+type ListenerConfig struct {
+	// Name identifies the listener in logs and is matched against the names of
+	// sockets passed via systemd socket activation
+	Name string `fig:"name"`
+	// Type is the type of the listener
+	Type ListenerType `fig:"type" default:"unix"`
+	// Addr and Port are used by network listeners
+	Addr string `fig:"addr" default:"0.0.0.0"`
+	Port uint   `fig:"port" default:"9099"`
+	// Path, Mode, Owner and Group are used by UNIX socket listeners. Mode is
+	// given in octal notation (e.g. "0666").
+	Path  string `fig:"path" default:"/var/tmp/logranger.sock"`
+	Mode  string `fig:"mode"`
+	Owner string `fig:"owner"`
+	Group string `fig:"group"`
+	// CertPath, KeyPath and the client certificate settings are used by TLS listeners
+	CertPath     string        `fig:"cert_path"`
+	KeyPath      string        `fig:"key_path"`
+	ClientCAPath string        `fig:"client_ca_path"`
+	ClientAuth   TLSClientAuth `fig:"client_auth"`
+	ClientAllow  []string      `fig:"client_allow"`
+	// MinVersion, CipherSuites and CurvePreferences harden the TLS settings. Only
+	// cipher suites without known security issues are accepted.
+	MinVersion       string   `fig:"min_version" default:"1.2"`
+	CipherSuites     []string `fig:"cipher_suites"`
+	CurvePreferences []string `fig:"curve_preferences"`
+	// CertReloadInterval is the interval in which the certificate and key files of
+	// a TLS listener are checked for changes. The certificate is reloaded on SIGHUP
+	// as well.
+	CertReloadInterval time.Duration `fig:"cert_reload_interval" default:"30s"`
+	// Framing is the RFC6587 framing method used by stream listeners
+	Framing Framing `fig:"framing" default:"auto"`
+	// MaxMessageSize is the maximum size of a single message in bytes on stream and
+	// HTTP listeners. It defaults to DefaultMaxMessageSize. OversizePolicy defines if
+	// oversize messages are truncated or dropped, which closes the connection. RELP and
+	// GELF TCP listeners always drop oversize messages.
+	MaxMessageSize uint           `fig:"max_message_size"`
+	OversizePolicy OversizePolicy `fig:"oversize_policy" default:"truncate"`
+	// ProxyProtocol enables the HAProxy PROXY protocol (v1 and v2) for TCP, TLS and
+	// RELP listeners. ProxyAllow restricts the upstream peers that are allowed to send
+	// a PROXY protocol header to the given networks. If empty, all peers are allowed.
+	ProxyProtocol bool     `fig:"proxy_protocol"`
+	ProxyAllow    []string `fig:"proxy_allow"`
+	// Allow and Deny restrict the sources that may send messages to network listeners
+	// to the given networks in CIDR notation. Deny takes precedence over Allow. If
+	// Allow is empty, all sources that are not denied are allowed.
+	Allow []string `fig:"allow"`
+	Deny  []string `fig:"deny"`
+	// AuthTokens and HTTPPath are used by HTTP(S) listeners. Requests must carry one of
+	// the AuthTokens as bearer token.
+	AuthTokens []string `fig:"auth_tokens"`
+	HTTPPath   string   `fig:"http_path" default:"/"`
+	// MaxConnections and MaxConnectionsPerIP limit the number of concurrent connections
+	// of stream listeners in total and per remote IP address. Excess connections are
+	// refused. A value of zero disables the limit.
+	MaxConnections      uint `fig:"max_connections"`
+	MaxConnectionsPerIP uint `fig:"max_connections_per_ip"`
+	// IdleTimeout is the maximum duration a stream connection may be idle between two
+	// messages. ReadTimeout is the maximum duration for reading a single message (and
+	// the connection handshake) once data is available. Both default to Timeout.
+	IdleTimeout time.Duration `fig:"idle_timeout"`
+	ReadTimeout time.Duration `fig:"read_timeout"`
+	// Parser and Timeout override the global parser settings for this listener
+	Parser  string        `fig:"parser"`
+	Timeout time.Duration `fig:"timeout"`
+	// Facility and Severity are used by the raw parser, given as numerical codes as in
+	// RFC5424. If ResolveHostnames is set, the raw parser reverse-resolves the IP address
+	// of the sender to determine the hostname of the message.
+	Facility         uint `fig:"facility" default:"1"`
+	Severity         uint `fig:"severity" default:"6"`
+	ResolveHostnames bool `fig:"resolve_hostnames"`
+
+	parserType parsesyslog.ParserType
+}
+
+// FileInputConfig holds the configuration settings of a single file-tailing input.
+// Each line of the followed files is wrapped into a log message with the configured
+// hostname, appname, facility and severity.
+type FileInputConfig struct {
+	// Name identifies the input in logs
+	Name string `fig:"name"`
+	// Paths is a list of file globs to follow
+	Paths []string `fig:"paths" validate:"required"`
+	// Hostname defaults to the hostname of the system
+	Hostname string `fig:"hostname"`
+	AppName  string `fig:"appname" default:"logranger"`
+	// Facility and Severity are given as numerical codes as in RFC5424
+	Facility uint `fig:"facility" default:"1"`
+	Severity uint `fig:"severity" default:"6"`
+	// OffsetFile is the file in which the read offsets are persisted. It defaults to
+	// a file named after the input in /var/tmp.
+	OffsetFile   string        `fig:"offset_file"`
+	PollInterval time.Duration `fig:"poll_interval" default:"1s"`
+	// FromBeginning reads files without a persisted offset from the beginning instead
+	// of from the end when the input is started
+	FromBeginning bool `fig:"from_beginning"`
 }
 
 // NewConfig creates a new instance of the Config object by reading and loading
@@ -67,14 +204,145 @@ func NewConfig(path, file string) (*Config, error) {
 		return &config, fmt.Errorf("failed to load config: %w", err)
 	}
 
-	switch {
-	case strings.EqualFold(config.Parser.Type, "rfc3164"):
-		config.internal.ParserType = rfc3164.Type
-	case strings.EqualFold(config.Parser.Type, "rfc5424"):
-		config.internal.ParserType = rfc5424.Type
-	default:
-		return nil, fmt.Errorf("unknown parser type: %s", config.Parser.Type)
+	if len(config.Listeners) == 0 {
+		switch config.Listener.Type {
+		case ListenerUnix, ListenerTCP, ListenerTLS, ListenerUDP:
+		default:
+			return nil, fmt.Errorf("listener type %q requires a [[listeners]] configuration",
+				config.Listener.Type)
+		}
+		config.Listeners = []ListenerConfig{config.legacyListener()}
+	}
+	names := make(map[string]struct{})
+	for i := range config.Listeners {
+		listenerConf := &config.Listeners[i]
+		if listenerConf.Name == "" {
+			listenerConf.Name = fmt.Sprintf("listener%d", i)
+		}
+		if _, ok := names[listenerConf.Name]; ok {
+			return nil, fmt.Errorf("duplicate listener name found: %s", listenerConf.Name)
+		}
+		names[listenerConf.Name] = struct{}{}
+
+		if listenerConf.Parser == "" {
+			listenerConf.Parser = config.Parser.Type
+		}
+		parserType, err := parserTypeFromString(listenerConf.Parser)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parser for listener %q: %w", listenerConf.Name, err)
+		}
+		listenerConf.parserType = parserType
+		if listenerConf.Facility > 23 || listenerConf.Severity > 7 {
+			return nil, fmt.Errorf("invalid facility or severity for listener %q", listenerConf.Name)
+		}
+		if listenerConf.Timeout == 0 {
+			listenerConf.Timeout = config.Parser.Timeout
+		}
+		if listenerConf.MaxMessageSize == 0 {
+			listenerConf.MaxMessageSize = DefaultMaxMessageSize
+		}
+		if listenerConf.IdleTimeout == 0 {
+			listenerConf.IdleTimeout = listenerConf.Timeout
+		}
+		if listenerConf.ReadTimeout == 0 {
+			listenerConf.ReadTimeout = listenerConf.Timeout
+		}
+		if listenerConf.Type.IsHTTPListener() && len(listenerConf.AuthTokens) == 0 {
+			return nil, fmt.Errorf("no auth_tokens configured for HTTP listener %q", listenerConf.Name)
+		}
+		if listenerConf.Type.IsHTTPListener() && !strings.HasPrefix(listenerConf.HTTPPath, "/") {
+			return nil, fmt.Errorf("http_path of HTTP listener %q must start with a slash: %q",
+				listenerConf.Name, listenerConf.HTTPPath)
+		}
+		if listenerConf.ProxyProtocol && (listenerConf.Type.IsHTTPListener() ||
+			listenerConf.Type.IsPacketListener()) {
+			return nil, fmt.Errorf("proxy_protocol is not supported by %s listener %q",
+				listenerConf.Type, listenerConf.Name)
+		}
+	}
+
+	if config.Queue.Workers <= 0 {
+		config.Queue.Workers = runtime.NumCPU()
+	}
+	if config.Queue.Size < 0 {
+		return nil, fmt.Errorf("invalid queue size: %d", config.Queue.Size)
+	}
+	if config.Spool.Enabled && config.Spool.SegmentSize <= 0 {
+		return nil, fmt.Errorf("invalid spool segment size: %d", config.Spool.SegmentSize)
+	}
+
+	for i := range config.FileInputs {
+		inputConf := &config.FileInputs[i]
+		if inputConf.Name == "" {
+			inputConf.Name = fmt.Sprintf("file%d", i)
+		}
+		if _, ok := names[inputConf.Name]; ok {
+			return nil, fmt.Errorf("duplicate input name found: %s", inputConf.Name)
+		}
+		names[inputConf.Name] = struct{}{}
+		if inputConf.OffsetFile == "" {
+			inputConf.OffsetFile = fmt.Sprintf("/var/tmp/logranger-%s.offsets", inputConf.Name)
+		}
+		if inputConf.Facility > 23 || inputConf.Severity > 7 {
+			return nil, fmt.Errorf("invalid facility or severity for input %q", inputConf.Name)
+		}
+		if inputConf.PollInterval <= 0 {
+			return nil, fmt.Errorf("invalid poll interval for input %q", inputConf.Name)
+		}
 	}
 
 	return &config, nil
 }
+
+// legacyListener returns a ListenerConfig based on the single listener configured
+// in the Listener block of the Config.
+func (c *Config) legacyListener() ListenerConfig {
+	listenerConf := ListenerConfig{
+		Type:     c.Listener.Type,
+		Path:     c.Listener.ListenerUnix.Path,
+		Parser:   c.Parser.Type,
+		Timeout:  c.Parser.Timeout,
+		Framing:  FramingAuto,
+		Facility: 1,
+		Severity: 6,
+	}
+	switch c.Listener.Type {
+	case ListenerTCP:
+		listenerConf.Addr = c.Listener.ListenerTCP.Addr
+		listenerConf.Port = c.Listener.ListenerTCP.Port
+		listenerConf.Framing = c.Listener.ListenerTCP.Framing
+	case ListenerTLS:
+		listenerConf.Addr = c.Listener.ListenerTLS.Addr
+		listenerConf.Port = c.Listener.ListenerTLS.Port
+		listenerConf.CertPath = c.Listener.ListenerTLS.CertPath
+		listenerConf.KeyPath = c.Listener.ListenerTLS.KeyPath
+		listenerConf.ClientCAPath = c.Listener.ListenerTLS.ClientCAPath
+		listenerConf.ClientAuth = c.Listener.ListenerTLS.ClientAuth
+		listenerConf.ClientAllow = c.Listener.ListenerTLS.ClientAllow
+		listenerConf.MinVersion = c.Listener.ListenerTLS.MinVersion
+		listenerConf.CipherSuites = c.Listener.ListenerTLS.CipherSuites
+		listenerConf.CurvePreferences = c.Listener.ListenerTLS.CurvePreferences
+		listenerConf.CertReloadInterval = c.Listener.ListenerTLS.CertReloadInterval
+		listenerConf.Framing = c.Listener.ListenerTLS.Framing
+	case ListenerUDP:
+		listenerConf.Addr = c.Listener.ListenerUDP.Addr
+		listenerConf.Port = c.Listener.ListenerUDP.Port
+	}
+	return listenerConf
+}
+
+// parserTypeFromString returns the parsesyslog.ParserType for the given parser name
+func parserTypeFromString(name string) (parsesyslog.ParserType, error) {
+	switch {
+	case strings.EqualFold(name, "rfc3164"):
+		return rfc3164.Type, nil
+	case strings.EqualFold(name, "rfc5424"):
+		return rfc5424.Type, nil
+	case strings.EqualFold(name, "auto"):
+		return ParserAuto, nil
+	case strings.EqualFold(name, "raw"):
+		return ParserRaw, nil
+	default:
+		return "", fmt.Errorf("unknown parser type: %s", name)
+	}
+}
@@ -6,17 +6,22 @@ package logranger
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"math/rand"
 	"net"
+	"strings"
+	"time"
 )
 
 // Connection represents a connection to a network resource.
 type Connection struct {
-	conn net.Conn
-	id   string
-	rb   *bufio.Reader
-	wb   *bufio.Writer
+	conn     net.Conn
+	id       string
+	listener *listenerInstance
+	meta     map[string]string
+	rb       *bufio.Reader
+	wb       *bufio.Writer
 }
 
 // NewConnection creates a new Connection object with the provided net.Conn.
@@ -26,6 +31,7 @@ func NewConnection(netConn net.Conn) *Connection {
 	connection := &Connection{
 		conn: netConn,
 		id:   NewConnectionID(),
+		meta: make(map[string]string),
 		rb:   bufio.NewReader(netConn),
 		wb:   bufio.NewWriter(netConn),
 	}
@@ -37,3 +43,72 @@ func NewConnection(netConn net.Conn) *Connection {
 func NewConnectionID() string {
 	return fmt.Sprintf("%x", rand.Int63())
 }
+
+// Handshake reads the PROXY protocol header if the listener of the Connection has the
+// PROXY protocol enabled. Afterwards it performs the TLS handshake if the Connection
+// is a TLS connection and stores the identity of a verified client certificate in the
+// metadata of the Connection. For UNIX socket connections, the credentials of the peer
+// process are stored in the metadata instead, if the platform supports it. For all
+// other connections, Handshake is a no-op.
+func (c *Connection) Handshake() error {
+	if err := c.readProxyHeader(); err != nil {
+		return err
+	}
+	if unixConn, ok := c.conn.(*net.UnixConn); ok {
+		if credentials, err := connPeerCredentials(unixConn); err == nil {
+			credentials.setMetadata(c.meta)
+		}
+		return nil
+	}
+
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	tlsClientMetadata(tlsConn.ConnectionState(), c.meta)
+	return nil
+}
+
+// readProxyHeader reads the PROXY protocol header if the listener of the Connection
+// has the PROXY protocol enabled. The header is only read once, so it is safe to call
+// readProxyHeader before Handshake.
+func (c *Connection) readProxyHeader() error {
+	netConn := c.conn
+	if tlsConn, ok := c.conn.(*tls.Conn); ok {
+		netConn = tlsConn.NetConn()
+	}
+	if proxied, ok := netConn.(*proxyConn); ok {
+		return proxied.ReadHeader()
+	}
+	return nil
+}
+
+// awaitData waits up to the idle timeout of the listener for the next message to
+// arrive on the Connection. Once data is available, the deadline is set to the read
+// timeout of the listener, which limits the time for reading the complete message.
+func (c *Connection) awaitData() error {
+	conf := c.listener.conf
+	if err := c.conn.SetDeadline(time.Now().Add(conf.IdleTimeout)); err != nil {
+		return err
+	}
+	if _, err := c.rb.Peek(1); err != nil {
+		return err
+	}
+	return c.conn.SetDeadline(time.Now().Add(conf.ReadTimeout))
+}
+
+// tlsClientMetadata stores the identity of the verified client certificate of the
+// given TLS connection state in the given metadata map. If no verified client
+// certificate is present, the metadata map is left untouched.
+func tlsClientMetadata(state tls.ConnectionState, meta map[string]string) {
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return
+	}
+	clientCert := state.PeerCertificates[0]
+	meta["tls_client_cn"] = clientCert.Subject.CommonName
+	meta["tls_client_dn"] = clientCert.Subject.String()
+	meta["tls_client_san"] = strings.Join(certSANs(clientCert), ",")
+}
@@ -9,3 +9,32 @@ import "errors"
 // ErrCertConfigEmpty is returned if a TLS listener is configured but ot certificate
 // or key paths are set
 var ErrCertConfigEmpty = errors.New("certificate and key paths are required for listener type: TLS")
+
+// ErrClientCAEmpty is returned if client certificate verification or a client allowlist
+// is configured for a TLS listener but no client CA path is set
+var ErrClientCAEmpty = errors.New("client CA path is required for client certificate verification")
+
+// ErrClientNotAllowed is returned if the verified client certificate of a TLS connection
+// does not match any entry of the configured client allowlist
+var ErrClientNotAllowed = errors.New("client certificate identity is not allowed")
+
+// ErrInvalidFrame is returned if a message frame on a stream listener does not
+// conform to the configured framing method
+var ErrInvalidFrame = errors.New("invalid message frame")
+
+// ErrMessageTooLarge is returned if a message on a stream listener exceeds the
+// configured maximum message size of the listener or if a chunked GELF message
+// exceeds the maximum GELF message size
+var ErrMessageTooLarge = errors.New("message exceeds maximum message size")
+
+// ErrQueueFull is returned if a message is dropped because the processing queue is
+// full and the queue policy is set to drop
+var ErrQueueFull = errors.New("processing queue is full")
+
+// ErrInvalidProxyHeader is returned if a connection of a listener with enabled PROXY
+// protocol does not start with a valid PROXY protocol header
+var ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+// ErrServerShutdown is returned if the Server is started or reloaded after Shutdown
+// has been called
+var ErrServerShutdown = errors.New("server is shut down")
@@ -0,0 +1,293 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/wneessen/go-parsesyslog"
+	"github.com/wneessen/go-parsesyslog/rfc5424"
+)
+
+// Framing is an enumeration wrapper for the different message framing methods
+// on stream listeners as described in RFC6587
+type Framing uint
+
+const (
+	// FramingAuto is a constant of type Framing that represents the automatic detection
+	// of the framing method for each message on the stream.
+	FramingAuto Framing = iota
+	// FramingOctet is a constant of type Framing that represents the octet-counting
+	// framing method (RFC6587, section 3.4.1).
+	FramingOctet
+	// FramingLF is a constant of type Framing that represents the non-transparent
+	// framing method with a LF as trailer (RFC6587, section 3.4.2).
+	FramingLF
+)
+
+// OversizePolicy is an enumeration wrapper for the different ways of handling messages
+// that exceed the maximum message size of a stream listener
+type OversizePolicy uint
+
+const (
+	// OversizeTruncate is a constant of type OversizePolicy that represents truncating
+	// oversize messages to the maximum message size. The TruncationMarker is appended
+	// to the truncated message.
+	OversizeTruncate OversizePolicy = iota
+	// OversizeDrop is a constant of type OversizePolicy that represents dropping oversize
+	// messages and closing the connection.
+	OversizeDrop
+)
+
+// DefaultMaxMessageSize is the maximum message size of a stream listener if none
+// is configured
+const DefaultMaxMessageSize = 64 * 1024
+
+// TruncationMarker is appended to messages that have been truncated to the maximum
+// message size of a listener
+const TruncationMarker = "...[truncated]"
+
+// maxMsgLenDigits is the maximum amount of digits accepted for the MSG-LEN field
+// of an octet-counted frame
+const maxMsgLenDigits = 10
+
+// ReadFrame reads a single message frame from the given bufio.Reader based on the
+// given Framing method and returns the message without any framing information.
+// If FramingAuto is given, the framing method is detected for each message based on
+// its beginning: a MSG-LEN followed by a space and the "<" of a syslog PRI indicates
+// octet-counting, everything else is treated as non-transparent framing. This keeps
+// raw lines that start with a digit, like a time or an IP address, intact.
+// If the message exceeds maxSize bytes, ErrMessageTooLarge is returned. With the
+// OversizeTruncate policy, the remainder of the message is discarded and the first
+// maxSize bytes are returned along with the error. With the OversizeDrop policy, no
+// message is returned and the remainder of the message is left unread.
+func ReadFrame(reader *bufio.Reader, framing Framing, maxSize int, policy OversizePolicy) ([]byte, error) {
+	if framing == FramingAuto {
+		if err := skipEmptyLines(reader); err != nil {
+			return nil, err
+		}
+		octet, err := isOctetFrame(reader)
+		if err != nil {
+			return nil, err
+		}
+		framing = FramingLF
+		if octet {
+			framing = FramingOctet
+		}
+	}
+
+	switch framing {
+	case FramingOctet:
+		return readOctetFrame(reader, maxSize, policy)
+	case FramingLF:
+		return readLFFrame(reader, maxSize, policy)
+	default:
+		return nil, fmt.Errorf("unsupported framing method: %s", framing)
+	}
+}
+
+// isOctetFrame returns true if the next frame of the given bufio.Reader starts with
+// "MSG-LEN SP <". The frame is peeked byte by byte, so that it never waits for more
+// data than the first character that rules out octet-counting.
+func isOctetFrame(reader *bufio.Reader) (bool, error) {
+	for length := 1; length <= maxMsgLenDigits+2; length++ {
+		peek, err := reader.Peek(length)
+		if err != nil {
+			if length > 1 && errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, err
+		}
+		char := peek[length-1]
+		switch {
+		case char >= '0' && char <= '9' && length <= maxMsgLenDigits:
+			continue
+		case char == ' ' && length > 1:
+			peek, err = reader.Peek(length + 1)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return false, nil
+				}
+				return false, err
+			}
+			return peek[length] == '<', nil
+		default:
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// readOctetFrame reads an octet-counted frame in the form of "MSG-LEN SP SYSLOG-MSG"
+// from the given bufio.Reader and returns the SYSLOG-MSG part.
+func readOctetFrame(reader *bufio.Reader, maxSize int, policy OversizePolicy) ([]byte, error) {
+	if err := skipEmptyLines(reader); err != nil {
+		return nil, err
+	}
+	msgLen := 0
+	for digits := 0; ; digits++ {
+		char, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if char == ' ' && digits > 0 {
+			break
+		}
+		if char < '0' || char > '9' || digits >= maxMsgLenDigits || (digits == 0 && char == '0') {
+			return nil, fmt.Errorf("%w: unexpected character %q in MSG-LEN", ErrInvalidFrame, char)
+		}
+		msgLen = msgLen*10 + int(char-'0')
+	}
+
+	if msgLen > maxSize && policy == OversizeDrop {
+		return nil, fmt.Errorf("%w: MSG-LEN of %d bytes", ErrMessageTooLarge, msgLen)
+	}
+	frame := make([]byte, min(msgLen, maxSize))
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		return nil, err
+	}
+	if msgLen > maxSize {
+		if _, err := reader.Discard(msgLen - maxSize); err != nil {
+			return nil, err
+		}
+		return frame, fmt.Errorf("%w: MSG-LEN of %d bytes", ErrMessageTooLarge, msgLen)
+	}
+	return frame, nil
+}
+
+// readLFFrame reads a non-transparent frame from the given bufio.Reader that is
+// terminated by a LF and returns the message without the trailer. A message that
+// is not terminated by a LF at the end of the stream is returned as well.
+func readLFFrame(reader *bufio.Reader, maxSize int, policy OversizePolicy) ([]byte, error) {
+	if err := skipEmptyLines(reader); err != nil {
+		return nil, err
+	}
+	var frame []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		frame = append(frame, chunk...)
+		if len(bytes.TrimRight(frame, "\r\n")) > maxSize {
+			return truncateLFFrame(reader, frame[:maxSize], err, policy)
+		}
+		switch {
+		case err == nil:
+			return bytes.TrimRight(frame, "\r\n"), nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(frame) > 0:
+			return bytes.TrimRight(frame, "\r\n"), nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// truncateLFFrame handles a non-transparent frame that exceeds the maximum message
+// size. With the OversizeTruncate policy, the remainder of the message up to and
+// including the LF trailer is discarded from the given bufio.Reader. The given
+// error is the error of the last read from the bufio.Reader.
+func truncateLFFrame(reader *bufio.Reader, frame []byte, err error, policy OversizePolicy) ([]byte, error) {
+	if policy == OversizeDrop {
+		return nil, ErrMessageTooLarge
+	}
+	for errors.Is(err, bufio.ErrBufferFull) {
+		_, err = reader.ReadSlice('\n')
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return frame, ErrMessageTooLarge
+}
+
+// skipEmptyLines discards any leading CR or LF characters from the given bufio.Reader
+func skipEmptyLines(reader *bufio.Reader) error {
+	for {
+		peek, err := reader.Peek(1)
+		if err != nil {
+			return err
+		}
+		if peek[0] != '\n' && peek[0] != '\r' {
+			return nil
+		}
+		if _, err = reader.Discard(1); err != nil {
+			return err
+		}
+	}
+}
+
+// frameReader returns an io.Reader for a single message frame in the form the
+// parser of the given type expects it. The RFC5424 parser requires the message to
+// be prefixed with its length (octet counting), while the RFC3164 parser expects
+// the message to be terminated by a newline.
+func frameReader(parserType parsesyslog.ParserType, frame []byte) io.Reader {
+	frame = bytes.TrimRight(frame, "\r\n\x00")
+	switch parserType {
+	case rfc5424.Type:
+		return io.MultiReader(strings.NewReader(strconv.Itoa(len(frame))+" "),
+			bytes.NewReader(frame))
+	default:
+		return io.MultiReader(bytes.NewReader(frame), strings.NewReader("\n"))
+	}
+}
+
+// UnmarshalString satisfies the fig.StringUnmarshaler interface for the Framing type
+func (f *Framing) UnmarshalString(value string) error {
+	switch strings.ToLower(value) {
+	case "auto":
+		*f = FramingAuto
+	case "octet":
+		*f = FramingOctet
+	case "lf":
+		*f = FramingLF
+	default:
+		return fmt.Errorf("unknown framing method: %s", value)
+	}
+	return nil
+}
+
+// UnmarshalString satisfies the fig.StringUnmarshaler interface for the OversizePolicy type
+func (p *OversizePolicy) UnmarshalString(value string) error {
+	switch strings.ToLower(value) {
+	case "truncate":
+		*p = OversizeTruncate
+	case "drop":
+		*p = OversizeDrop
+	default:
+		return fmt.Errorf("unknown oversize policy: %s", value)
+	}
+	return nil
+}
+
+// String satisfies the fmt.Stringer interface for the OversizePolicy type
+func (p OversizePolicy) String() string {
+	switch p {
+	case OversizeTruncate:
+		return "truncate"
+	case OversizeDrop:
+		return "drop"
+	default:
+		return "unknown"
+	}
+}
+
+// String satisfies the fmt.Stringer interface for the Framing type
+func (f Framing) String() string {
+	switch f {
+	case FramingAuto:
+		return "auto"
+	case FramingOctet:
+		return "octet"
+	case FramingLF:
+		return "lf"
+	default:
+		return "unknown"
+	}
+}
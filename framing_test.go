@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type testFrame struct {
+	data string
+	err  error
+}
+
+func TestReadFrame(t *testing.T) {
+	longLine := strings.Repeat("x", 40)
+	tests := []struct {
+		name    string
+		input   string
+		framing Framing
+		maxSize int
+		policy  OversizePolicy
+		bufSize int
+		want    []testFrame
+	}{
+		{
+			"LF framing", "<13>foo\n<13>bar\n", FramingLF, 1024, OversizeTruncate, 0,
+			[]testFrame{{data: "<13>foo"}, {data: "<13>bar"}},
+		},
+		{
+			"LF framing with CRLF, empty lines and no trailer", "\r\n<13>foo\r\n\n<13>bar", FramingLF,
+			1024, OversizeTruncate, 0, []testFrame{{data: "<13>foo"}, {data: "<13>bar"}},
+		},
+		{
+			"LF framing exceeding the buffer", longLine + "\n<13>bar\n", FramingLF, 1024,
+			OversizeTruncate, 16, []testFrame{{data: longLine}, {data: "<13>bar"}},
+		},
+		{
+			"octet framing", "7 <13>foo7 <13>bar", FramingOctet, 1024, OversizeTruncate, 0,
+			[]testFrame{{data: "<13>foo"}, {data: "<13>bar"}},
+		},
+		{
+			"octet framing with embedded LF", "8 <13>a\nbc\n", FramingOctet, 1024, OversizeTruncate, 0,
+			[]testFrame{{data: "<13>a\nbc"}},
+		},
+		{
+			"octet framing with invalid MSG-LEN", "abc <13>foo", FramingOctet, 1024, OversizeTruncate, 0,
+			[]testFrame{{err: ErrInvalidFrame}},
+		},
+		{
+			"octet framing with leading zero", "07 <13>foo", FramingOctet, 1024, OversizeTruncate, 0,
+			[]testFrame{{err: ErrInvalidFrame}},
+		},
+		{
+			"octet framing with too many digits", "12345678901 <13>foo", FramingOctet, 1024,
+			OversizeTruncate, 0, []testFrame{{err: ErrInvalidFrame}},
+		},
+		{
+			"octet framing with short message", "10 <13>foo", FramingOctet, 1024, OversizeTruncate, 0,
+			[]testFrame{{err: io.ErrUnexpectedEOF}},
+		},
+		{
+			"auto framing with mixed frames", "7 <13>foo\n<13>bar\n8 <13>a\nbc", FramingAuto, 1024,
+			OversizeTruncate, 0, []testFrame{{data: "<13>foo"}, {data: "<13>bar"}, {data: "<13>a\nbc"}},
+		},
+		{
+			"auto framing with raw lines starting with digits",
+			"12:00:01 started\n10.0.0.1 connected\n42 is the answer\n7\n", FramingAuto, 1024,
+			OversizeTruncate, 0, []testFrame{
+				{data: "12:00:01 started"}, {data: "10.0.0.1 connected"},
+				{data: "42 is the answer"}, {data: "7"},
+			},
+		},
+		{
+			"auto framing with MSG-LEN at the end of the stream", "7 ", FramingAuto, 1024,
+			OversizeTruncate, 0, []testFrame{{data: "7 "}},
+		},
+		{
+			"LF framing with truncate policy", "<13>foobar\n<13>x\n", FramingLF, 5, OversizeTruncate, 0,
+			[]testFrame{{data: "<13>f", err: ErrMessageTooLarge}, {data: "<13>x"}},
+		},
+		{
+			"LF framing with truncate policy exceeding the buffer", longLine + "\n<13>x\n", FramingLF, 5,
+			OversizeTruncate, 16, []testFrame{{data: "xxxxx", err: ErrMessageTooLarge}, {data: "<13>x"}},
+		},
+		{
+			"LF framing with drop policy", "<13>foobar\n<13>x\n", FramingLF, 5, OversizeDrop, 0,
+			[]testFrame{{err: ErrMessageTooLarge}},
+		},
+		{
+			"octet framing with truncate policy", "10 <13>foobar5 <13>x", FramingOctet, 5,
+			OversizeTruncate, 0, []testFrame{{data: "<13>f", err: ErrMessageTooLarge}, {data: "<13>x"}},
+		},
+		{
+			"octet framing with drop policy", "10 <13>foobar7 <13>baz", FramingOctet, 5, OversizeDrop, 0,
+			[]testFrame{{err: ErrMessageTooLarge}},
+		},
+		{
+			"unsupported framing", "<13>foo\n", Framing(99), 1024, OversizeTruncate, 0,
+			[]testFrame{{err: errors.New("unsupported framing method: unknown")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.input))
+			if tt.bufSize > 0 {
+				reader = bufio.NewReaderSize(strings.NewReader(tt.input), tt.bufSize)
+			}
+			var got []testFrame
+			for {
+				frame, err := ReadFrame(reader, tt.framing, tt.maxSize, tt.policy)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				got = append(got, testFrame{data: string(frame), err: err})
+				if frame == nil {
+					break
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ReadFrame returned %d frames, want %d: %v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				if got[i].data != want.data {
+					t.Errorf("frame %d: got %q, want %q", i, got[i].data, want.data)
+				}
+				if !sameError(got[i].err, want.err) {
+					t.Errorf("frame %d: got error %v, want %v", i, got[i].err, want.err)
+				}
+			}
+		})
+	}
+}
+
+func TestFraming_UnmarshalString(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Framing
+		wantErr bool
+	}{
+		{"auto", FramingAuto, false},
+		{"Octet", FramingOctet, false},
+		{"LF", FramingLF, false},
+		{"crlf", FramingAuto, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var framing Framing
+			err := framing.UnmarshalString(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalString(%q) returned error %v, want error: %t", tt.value, err, tt.wantErr)
+			}
+			if framing != tt.want {
+				t.Errorf("UnmarshalString(%q) = %s, want %s", tt.value, framing, tt.want)
+			}
+		})
+	}
+}
+
+// sameError returns true if err matches want. Errors that are not sentinel errors
+// are compared by their message.
+func sameError(err, want error) bool {
+	if err == nil || want == nil {
+		return err == want
+	}
+	return errors.Is(err, want) || err.Error() == want.Error()
+}
@@ -0,0 +1,364 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wneessen/go-parsesyslog"
+
+	"github.com/wneessen/logranger/metadata"
+)
+
+const (
+	// gelfChunkHeaderLen is the length of the header of a chunked GELF datagram, consisting
+	// of the 2 magic bytes, the 8 byte message ID, the sequence number and the sequence count
+	gelfChunkHeaderLen = 12
+	// gelfMaxChunks is the maximum amount of chunks a GELF message may consist of
+	gelfMaxChunks = 128
+	// gelfChunkTimeout is the maximum duration to wait for all chunks of a GELF message
+	gelfChunkTimeout = 5 * time.Second
+	// gelfMaxPendingMessages is the maximum amount of incomplete chunked GELF messages
+	// that are held in memory per listener
+	gelfMaxPendingMessages = 1024
+	// gelfMaxPendingBytes is the maximum total size of the chunks of incomplete GELF
+	// messages that are held in memory per listener
+	gelfMaxPendingBytes = 32 * 1024 * 1024
+	// gelfMaxMessageSize is the maximum size of a single (decompressed) GELF message. On
+	// GELF TCP listeners, it is the upper bound for the max_message_size of the listener.
+	gelfMaxMessageSize = 8 * 1024 * 1024
+	// gelfDefaultLevel is the syslog severity used for GELF messages without level, as
+	// defined in the GELF specification
+	gelfDefaultLevel = 1
+)
+
+// MetaGELFPrefix is the prefix of the metadata keys of the full_message and the
+// additional fields of a GELF message. It keeps fields set by the sender apart from
+// the metadata set by logranger itself, like the verified identity of a TLS client.
+const MetaGELFPrefix = "gelf_"
+
+// gelfChunkMagic are the magic bytes that identify a chunked GELF datagram
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// gelfAssembler reassembles chunked GELF messages received by a GELF UDP listener.
+// Incomplete messages are discarded after gelfChunkTimeout. The memory held by
+// incomplete messages is limited to gelfMaxPendingBytes.
+type gelfAssembler struct {
+	mutex        sync.Mutex
+	pending      map[string]*gelfChunks
+	pendingBytes int
+}
+
+// gelfChunks holds the chunks of a single GELF message received so far
+type gelfChunks struct {
+	chunks    [][]byte
+	firstSeen time.Time
+	received  int
+	size      int
+}
+
+// newGELFAssembler returns a new, empty gelfAssembler
+func newGELFAssembler() *gelfAssembler {
+	return &gelfAssembler{pending: make(map[string]*gelfChunks)}
+}
+
+// Add adds the given datagram to the assembler. If the datagram is not chunked, it is
+// returned as is. If the datagram completes a chunked message, the reassembled message
+// is returned. Otherwise, nil is returned. Chunked messages that exceed
+// gelfMaxMessageSize are discarded, as are chunks that exceed the memory budget of
+// the assembler.
+func (a *gelfAssembler) Add(datagram []byte, remoteAddr net.Addr) ([]byte, error) {
+	if !bytes.HasPrefix(datagram, gelfChunkMagic) {
+		return datagram, nil
+	}
+	if len(datagram) < gelfChunkHeaderLen {
+		return nil, fmt.Errorf("%w: GELF chunk header too short", ErrInvalidFrame)
+	}
+	sequence, count := int(datagram[10]), int(datagram[11])
+	if count == 0 || count > gelfMaxChunks || sequence >= count {
+		return nil, fmt.Errorf("%w: invalid GELF chunk sequence %d/%d", ErrInvalidFrame,
+			sequence, count)
+	}
+	messageID := addrString(remoteAddr) + string(datagram[2:10])
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	now := time.Now()
+	for id, message := range a.pending {
+		if now.Sub(message.firstSeen) > gelfChunkTimeout {
+			a.discard(id)
+		}
+	}
+	message, ok := a.pending[messageID]
+	if !ok {
+		if len(a.pending) >= gelfMaxPendingMessages {
+			return nil, errors.New("too many incomplete chunked GELF messages")
+		}
+		message = &gelfChunks{chunks: make([][]byte, count), firstSeen: now}
+		a.pending[messageID] = message
+	}
+	if len(message.chunks) != count {
+		a.discard(messageID)
+		return nil, fmt.Errorf("%w: GELF chunk count mismatch", ErrInvalidFrame)
+	}
+	if message.chunks[sequence] == nil {
+		chunk := datagram[gelfChunkHeaderLen:]
+		if message.size+len(chunk) > gelfMaxMessageSize {
+			a.discard(messageID)
+			return nil, ErrMessageTooLarge
+		}
+		if a.pendingBytes+len(chunk) > gelfMaxPendingBytes {
+			if message.received == 0 {
+				a.discard(messageID)
+			}
+			return nil, errors.New("memory limit for incomplete chunked GELF messages reached")
+		}
+		message.chunks[sequence] = bytes.Clone(chunk)
+		message.received++
+		message.size += len(chunk)
+		a.pendingBytes += len(chunk)
+	}
+	if message.received < count {
+		return nil, nil
+	}
+	a.discard(messageID)
+	return bytes.Join(message.chunks, nil), nil
+}
+
+// discard removes the incomplete message with the given ID from the assembler and
+// releases its share of the memory budget. The mutex must be held by the caller.
+func (a *gelfAssembler) discard(messageID string) {
+	message, ok := a.pending[messageID]
+	if !ok {
+		return
+	}
+	a.pendingBytes -= message.size
+	delete(a.pending, messageID)
+}
+
+// handleGELFDatagram reassembles, decompresses and decodes a single datagram received
+// by a GELF UDP listener and hands the resulting log message over for processing.
+func (s *Server) handleGELFDatagram(instance *listenerInstance, datagram []byte, remoteAddr net.Addr) {
+	payload, err := instance.gelfChunks.Add(datagram, remoteAddr)
+	if err != nil {
+		s.log.Error("failed to read GELF chunk", LogErrKey, err,
+			slog.String("listener", instance.conf.Name),
+			slog.String("remote_addr", addrString(remoteAddr)))
+		return
+	}
+	if payload == nil {
+		return
+	}
+	meta := map[string]string{MetaInput: "gelf", MetaRemoteAddr: addrString(remoteAddr)}
+	s.acceptGELFMessage(instance, payload, meta)
+}
+
+// handleGELFConnection handles a single connection of a GELF TCP listener. Each GELF
+// message on the connection is terminated by a null byte.
+func (s *Server) handleGELFConnection(connection *Connection) {
+	defer func() {
+		if err := connection.conn.Close(); err != nil {
+			s.log.Error("failed to close connection", LogErrKey, err)
+		}
+	}()
+
+	instance := connection.listener
+	if err := connection.conn.SetDeadline(time.Now().Add(instance.conf.ReadTimeout)); err != nil {
+		s.log.Error("failed to set processing deadline", LogErrKey, err,
+			slog.Duration("timeout", instance.conf.ReadTimeout))
+		return
+	}
+	if err := connection.Handshake(); err != nil {
+		s.log.Error("connection handshake failed", LogErrKey, err,
+			slog.String("remote_addr", connection.conn.RemoteAddr().String()))
+		return
+	}
+	connection.meta[MetaInput] = "gelf"
+	connection.meta[MetaRemoteAddr] = connection.conn.RemoteAddr().String()
+	maxSize := min(int(instance.conf.MaxMessageSize), gelfMaxMessageSize)
+
+	for {
+		if s.shuttingDown() && connection.rb.Buffered() == 0 {
+			return
+		}
+		err := connection.awaitData()
+		var frame []byte
+		if err == nil {
+			frame, err = ReadGELFFrame(connection.rb, maxSize)
+		}
+		if err != nil {
+			var netErr *net.OpError
+			switch {
+			case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+				if s.config().Log.Extended {
+					s.log.Error("GELF connection terminated", LogErrKey, err)
+				}
+			default:
+				s.log.Error("failed to read GELF frame", LogErrKey, err,
+					slog.String("listener", instance.conf.Name))
+			}
+			return
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		s.acceptGELFMessage(instance, frame, connection.meta)
+	}
+}
+
+// ReadGELFFrame reads a single null-delimited GELF frame from the given bufio.Reader.
+// Surrounding whitespace (e.g. a trailing newline) is removed from the frame. If the
+// frame exceeds maxSize bytes, ErrMessageTooLarge is returned.
+func ReadGELFFrame(reader *bufio.Reader, maxSize int) ([]byte, error) {
+	var frame []byte
+	for {
+		chunk, err := reader.ReadSlice(0)
+		frame = append(frame, chunk...)
+		if len(frame) > maxSize+1 {
+			return nil, fmt.Errorf("%w: GELF frame exceeds %d bytes", ErrMessageTooLarge, maxSize)
+		}
+		switch {
+		case err == nil:
+			return bytes.TrimSpace(frame[:len(frame)-1]), nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(bytes.TrimSpace(frame)) > 0:
+			return nil, io.ErrUnexpectedEOF
+		default:
+			return nil, err
+		}
+	}
+}
+
+// acceptGELFMessage decodes the given GELF payload and hands the resulting log
+// message over for processing
+func (s *Server) acceptGELFMessage(instance *listenerInstance, payload []byte, meta map[string]string) {
+	logMessage, err := DecodeGELF(payload)
+	if err != nil {
+		s.log.Error("failed to decode GELF message", LogErrKey, err,
+			slog.String("listener", instance.conf.Name))
+		return
+	}
+	_ = s.dispatchMessage(logMessage, meta)
+}
+
+// DecodeGELF decodes a single, optionally gzip or zlib compressed, GELF message and
+// returns it as parsesyslog.LogMsg. The host is mapped to the hostname, the
+// short_message to the message and the level to the severity. The full_message and
+// all additional fields (with the leading underscore replaced by MetaGELFPrefix) are
+// stored as metadata of the log message, so they can be matched by rules and used in
+// templates.
+func DecodeGELF(payload []byte) (parsesyslog.LogMsg, error) {
+	logMessage := parsesyslog.LogMsg{}
+	payload, err := gelfDecompress(payload)
+	if err != nil {
+		return logMessage, err
+	}
+
+	var fields map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err = decoder.Decode(&fields); err != nil {
+		return logMessage, fmt.Errorf("failed to decode GELF JSON: %w", err)
+	}
+	host, _ := fields["host"].(string)
+	shortMessage, _ := fields["short_message"].(string)
+	if host == "" || shortMessage == "" {
+		return logMessage, errors.New("GELF message is missing host or short_message")
+	}
+
+	logMessage.Host = []byte(host)
+	logMessage.Facility = 1
+	logMessage.Severity = gelfDefaultLevel
+	logMessage.Timestamp = time.Now()
+	logMessage.Message.WriteString(shortMessage)
+	if level, ok := fields["level"].(json.Number); ok {
+		severity, err := level.Int64()
+		if err != nil || severity < 0 || severity > 7 {
+			return logMessage, fmt.Errorf("invalid GELF level: %s", level)
+		}
+		logMessage.Severity = parsesyslog.Severity(severity)
+	}
+	if timestamp, ok := fields["timestamp"].(json.Number); ok {
+		seconds, err := timestamp.Float64()
+		if err != nil {
+			return logMessage, fmt.Errorf("invalid GELF timestamp: %s", timestamp)
+		}
+		whole, fraction := math.Modf(seconds)
+		logMessage.Timestamp = time.Unix(int64(whole), int64(fraction*float64(time.Second)))
+	}
+	if fullMessage, ok := fields["full_message"].(string); ok && fullMessage != "" {
+		metadata.Set(&logMessage, MetaGELFPrefix+"full_message", fullMessage)
+	}
+	for name, value := range fields {
+		if !strings.HasPrefix(name, "_") || name == "_id" || len(name) < 2 {
+			continue
+		}
+		metadata.Set(&logMessage, MetaGELFPrefix+name[1:], gelfFieldString(value))
+	}
+	return logMessage, nil
+}
+
+// gelfDecompress decompresses the given GELF payload if it is gzip or zlib compressed.
+// Uncompressed payloads are returned as is.
+func gelfDecompress(payload []byte) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	switch {
+	case len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		reader, err = gzip.NewReader(bytes.NewReader(payload))
+	case len(payload) >= 2 && payload[0]&0x0f == 0x08 && (uint16(payload[0])<<8|uint16(payload[1]))%31 == 0:
+		reader, err = zlib.NewReader(bytes.NewReader(payload))
+	default:
+		return payload, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress GELF message: %w", err)
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	decompressed, err := io.ReadAll(io.LimitReader(reader, gelfMaxMessageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress GELF message: %w", err)
+	}
+	if len(decompressed) > gelfMaxMessageSize {
+		return nil, fmt.Errorf("decompressed GELF message exceeds %d bytes", gelfMaxMessageSize)
+	}
+	return decompressed, nil
+}
+
+// gelfFieldString returns the string representation of the value of an additional
+// GELF field. The GELF specification only allows strings and numbers, other values
+// are represented as JSON.
+func gelfFieldString(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	case nil:
+		return ""
+	default:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+}
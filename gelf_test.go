@@ -0,0 +1,336 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wneessen/go-parsesyslog"
+
+	"github.com/wneessen/logranger/metadata"
+)
+
+func TestGELFAssembler_Add(t *testing.T) {
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12201}
+	otherClient := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 12201}
+	type datagram struct {
+		data    []byte
+		from    net.Addr
+		want    []byte
+		wantErr error
+	}
+	tests := []struct {
+		name      string
+		datagrams []datagram
+	}{
+		{
+			"unchunked datagram", []datagram{
+				{data: []byte(`{"short_message":"foo"}`), from: client, want: []byte(`{"short_message":"foo"}`)},
+			},
+		},
+		{
+			"chunks in order", []datagram{
+				{data: gelfChunk("message1", 0, 2, "foo"), from: client},
+				{data: gelfChunk("message1", 1, 2, "bar"), from: client, want: []byte("foobar")},
+			},
+		},
+		{
+			"chunks out of order", []datagram{
+				{data: gelfChunk("message1", 2, 3, "baz"), from: client},
+				{data: gelfChunk("message1", 0, 3, "foo"), from: client},
+				{data: gelfChunk("message1", 1, 3, "bar"), from: client, want: []byte("foobarbaz")},
+			},
+		},
+		{
+			"duplicate chunk", []datagram{
+				{data: gelfChunk("message1", 0, 2, "foo"), from: client},
+				{data: gelfChunk("message1", 0, 2, "xxx"), from: client},
+				{data: gelfChunk("message1", 1, 2, "bar"), from: client, want: []byte("foobar")},
+			},
+		},
+		{
+			"same message ID from different clients", []datagram{
+				{data: gelfChunk("message1", 0, 2, "foo"), from: client},
+				{data: gelfChunk("message1", 1, 2, "baz"), from: otherClient},
+				{data: gelfChunk("message1", 1, 2, "bar"), from: client, want: []byte("foobar")},
+				{data: gelfChunk("message1", 0, 2, "qux"), from: otherClient, want: []byte("quxbaz")},
+			},
+		},
+		{
+			"chunk header too short", []datagram{
+				{data: append(append([]byte{}, gelfChunkMagic...), "message1"...), from: client, wantErr: ErrInvalidFrame},
+			},
+		},
+		{
+			"sequence number exceeding sequence count", []datagram{
+				{data: gelfChunk("message1", 2, 2, "foo"), from: client, wantErr: ErrInvalidFrame},
+			},
+		},
+		{
+			"sequence count of zero", []datagram{
+				{data: gelfChunk("message1", 0, 0, "foo"), from: client, wantErr: ErrInvalidFrame},
+			},
+		},
+		{
+			"sequence count exceeding maximum", []datagram{
+				{data: gelfChunk("message1", 0, gelfMaxChunks+1, "foo"), from: client, wantErr: ErrInvalidFrame},
+			},
+		},
+		{
+			"sequence count mismatch", []datagram{
+				{data: gelfChunk("message1", 0, 2, "foo"), from: client},
+				{data: gelfChunk("message1", 1, 3, "bar"), from: client, wantErr: ErrInvalidFrame},
+				{data: gelfChunk("message1", 1, 2, "bar"), from: client},
+			},
+		},
+		{
+			"message exceeding maximum size", []datagram{
+				{data: gelfChunk("message1", 0, 2, string(make([]byte, gelfMaxMessageSize/2+1))), from: client},
+				{
+					data: gelfChunk("message1", 1, 2, string(make([]byte, gelfMaxMessageSize/2))), from: client,
+					wantErr: ErrMessageTooLarge,
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assembler := newGELFAssembler()
+			for i, datagram := range tt.datagrams {
+				got, err := assembler.Add(datagram.data, datagram.from)
+				if !errors.Is(err, datagram.wantErr) {
+					t.Fatalf("datagram %d: Add returned error %v, want %v", i, err, datagram.wantErr)
+				}
+				if !bytes.Equal(got, datagram.want) {
+					t.Errorf("datagram %d: Add = %q, want %q", i, got, datagram.want)
+				}
+			}
+			assertGELFPendingBytes(t, assembler)
+		})
+	}
+}
+
+func TestGELFAssembler_Add_limits(t *testing.T) {
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12201}
+	t.Run("incomplete messages expire", func(t *testing.T) {
+		assembler := newGELFAssembler()
+		if _, err := assembler.Add(gelfChunk("message1", 0, 2, "foo"), client); err != nil {
+			t.Fatalf("failed to add chunk: %s", err)
+		}
+		assembler.pending[addrString(client)+"message1"].firstSeen = time.Now().Add(-2 * gelfChunkTimeout)
+		got, err := assembler.Add(gelfChunk("message1", 1, 2, "bar"), client)
+		if err != nil {
+			t.Fatalf("failed to add chunk: %s", err)
+		}
+		if got != nil {
+			t.Errorf("expired message has been completed: %q", got)
+		}
+		if len(assembler.pending) != 1 || assembler.pendingBytes != 3 {
+			t.Errorf("expired message has not been discarded: %d pending messages, %d pending bytes",
+				len(assembler.pending), assembler.pendingBytes)
+		}
+	})
+	t.Run("too many incomplete messages", func(t *testing.T) {
+		assembler := newGELFAssembler()
+		for i := 0; i < gelfMaxPendingMessages; i++ {
+			messageID := gelfMessageID(uint64(i))
+			if _, err := assembler.Add(gelfChunk(messageID, 0, 2, "foo"), client); err != nil {
+				t.Fatalf("failed to add chunk %d: %s", i, err)
+			}
+		}
+		if _, err := assembler.Add(gelfChunk("overflow", 0, 2, "foo"), client); err == nil {
+			t.Error("expected error for too many incomplete messages")
+		}
+		assertGELFPendingBytes(t, assembler)
+	})
+	t.Run("memory limit", func(t *testing.T) {
+		assembler := newGELFAssembler()
+		chunk := string(make([]byte, gelfMaxMessageSize-1024))
+		var i uint64
+		for ; assembler.pendingBytes+len(chunk) <= gelfMaxPendingBytes; i++ {
+			if _, err := assembler.Add(gelfChunk(gelfMessageID(i), 0, 2, chunk), client); err != nil {
+				t.Fatalf("failed to add chunk %d: %s", i, err)
+			}
+		}
+		pending := len(assembler.pending)
+		if _, err := assembler.Add(gelfChunk(gelfMessageID(i), 0, 2, chunk), client); err == nil {
+			t.Fatal("expected error for exceeded memory limit")
+		}
+		if len(assembler.pending) != pending {
+			t.Errorf("rejected message is still pending")
+		}
+		got, err := assembler.Add(gelfChunk(gelfMessageID(0), 1, 2, "foo"), client)
+		if err != nil {
+			t.Fatalf("failed to complete pending message: %s", err)
+		}
+		if len(got) != len(chunk)+3 {
+			t.Errorf("completed message has %d bytes, want %d", len(got), len(chunk)+3)
+		}
+		assertGELFPendingBytes(t, assembler)
+	})
+}
+
+func TestReadGELFFrame(t *testing.T) {
+	const message = `{"host":"example.org","short_message":"A short message"}`
+	tests := []struct {
+		name    string
+		input   string
+		maxSize int
+		want    string
+		wantErr error
+	}{
+		{"null-delimited frame", message + "\x00", len(message), message, nil},
+		{"trailing newline", message + "\n\x00", len(message) + 1, message, nil},
+		{"frame exceeding max size", message + "\x00", len(message) - 1, "", ErrMessageTooLarge},
+		{"unterminated frame", message, len(message), "", io.ErrUnexpectedEOF},
+		{"empty stream", "", len(message), "", io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := ReadGELFFrame(bufio.NewReader(strings.NewReader(tt.input)), tt.maxSize)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadGELFFrame returned error %v, want %v", err, tt.wantErr)
+			}
+			if string(frame) != tt.want {
+				t.Errorf("ReadGELFFrame = %q, want %q", frame, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeGELF(t *testing.T) {
+	const minimal = `{"version":"1.1","host":"example.org","short_message":"A short message"}`
+	tests := []struct {
+		name         string
+		payload      []byte
+		wantErr      bool
+		wantSeverity parsesyslog.Severity
+		wantTime     time.Time
+		wantMeta     map[string]string
+	}{
+		{"minimal message", []byte(minimal), false, gelfDefaultLevel, time.Time{}, map[string]string{}},
+		{"gzip compressed message", gzipPayload(t, minimal), false, gelfDefaultLevel, time.Time{}, map[string]string{}},
+		{"zlib compressed message", zlibPayload(t, minimal), false, gelfDefaultLevel, time.Time{}, map[string]string{}},
+		{
+			"level and timestamp",
+			[]byte(`{"host":"example.org","short_message":"A short message","level":4,"timestamp":1385053862.5}`),
+			false, 4, time.Unix(1385053862, int64(500*time.Millisecond)), map[string]string{},
+		},
+		{
+			"full message and additional fields",
+			[]byte(`{"host":"example.org","short_message":"A short message","full_message":"Backtrace here",` +
+				`"_user_id":9001,"_some_info":"foo","_flag":true,"_object":{"a":1},"_id":"ignored","_":"ignored"}`),
+			false, gelfDefaultLevel, time.Time{}, map[string]string{
+				"gelf_full_message": "Backtrace here",
+				"gelf_user_id":      "9001",
+				"gelf_some_info":    "foo",
+				"gelf_flag":         "true",
+				"gelf_object":       `{"a":1}`,
+			},
+		},
+		{"missing host", []byte(`{"short_message":"A short message"}`), true, 0, time.Time{}, nil},
+		{"missing short message", []byte(`{"host":"example.org"}`), true, 0, time.Time{}, nil},
+		{
+			"invalid level", []byte(`{"host":"example.org","short_message":"A short message","level":8}`),
+			true, 0, time.Time{}, nil,
+		},
+		{"invalid JSON", []byte(`{"host":"example.org",`), true, 0, time.Time{}, nil},
+		{"corrupt gzip payload", []byte{0x1f, 0x8b, 0x00, 0x01}, true, 0, time.Time{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logMessage, err := DecodeGELF(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeGELF returned error %v, want error: %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if logMessage.Hostname() != "example.org" {
+				t.Errorf("hostname = %q, want %q", logMessage.Hostname(), "example.org")
+			}
+			if logMessage.Message.String() != "A short message" {
+				t.Errorf("message = %q, want %q", logMessage.Message.String(), "A short message")
+			}
+			if logMessage.Severity != tt.wantSeverity {
+				t.Errorf("severity = %d, want %d", logMessage.Severity, tt.wantSeverity)
+			}
+			if !tt.wantTime.IsZero() && !logMessage.Timestamp.Equal(tt.wantTime) {
+				t.Errorf("timestamp = %s, want %s", logMessage.Timestamp, tt.wantTime)
+			}
+			if meta := metadata.Map(logMessage); !reflect.DeepEqual(meta, tt.wantMeta) {
+				t.Errorf("metadata = %v, want %v", meta, tt.wantMeta)
+			}
+		})
+	}
+}
+
+// gelfChunk returns a chunked GELF datagram with the given 8 byte message ID, sequence
+// number and sequence count
+func gelfChunk(messageID string, sequence, count byte, data string) []byte {
+	datagram := append([]byte{}, gelfChunkMagic...)
+	datagram = append(datagram, messageID...)
+	datagram = append(datagram, sequence, count)
+	return append(datagram, data...)
+}
+
+// gelfMessageID returns the given number as 8 byte GELF message ID
+func gelfMessageID(id uint64) string {
+	return string(binary.BigEndian.AppendUint64(nil, id))
+}
+
+// assertGELFPendingBytes checks that the memory budget of the given gelfAssembler
+// matches the chunks it holds
+func assertGELFPendingBytes(t *testing.T, assembler *gelfAssembler) {
+	t.Helper()
+	size := 0
+	for _, message := range assembler.pending {
+		for _, chunk := range message.chunks {
+			size += len(chunk)
+		}
+	}
+	if assembler.pendingBytes != size {
+		t.Errorf("pending bytes = %d, want %d", assembler.pendingBytes, size)
+	}
+}
+
+// gzipPayload returns the given payload gzip compressed
+func gzipPayload(t *testing.T, payload string) []byte {
+	t.Helper()
+	buffer := bytes.NewBuffer(nil)
+	writer := gzip.NewWriter(buffer)
+	if _, err := writer.Write([]byte(payload)); err != nil {
+		t.Fatalf("failed to compress payload: %s", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to compress payload: %s", err)
+	}
+	return buffer.Bytes()
+}
+
+// zlibPayload returns the given payload zlib compressed
+func zlibPayload(t *testing.T, payload string) []byte {
+	t.Helper()
+	buffer := bytes.NewBuffer(nil)
+	writer := zlib.NewWriter(buffer)
+	if _, err := writer.Write([]byte(payload)); err != nil {
+		t.Fatalf("failed to compress payload: %s", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to compress payload: %s", err)
+	}
+	return buffer.Bytes()
+}
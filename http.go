@@ -0,0 +1,251 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/wneessen/go-parsesyslog"
+)
+
+const (
+	// maxHTTPBodySize is the maximum size of a request body accepted by the HTTP listener
+	maxHTTPBodySize = 10 * 1024 * 1024
+	// httpReadTimeout is the maximum duration for reading a request of the HTTP listener
+	httpReadTimeout = 30 * time.Second
+)
+
+// MetaRemoteAddr is the metadata key under which the address of the client that
+// submitted a message via HTTP is stored
+const MetaRemoteAddr = "remote_addr"
+
+// HTTPMessage represents a single log message submitted as JSON object to the HTTP
+// listener. Hostname and Message are required, all other fields are optional.
+type HTTPMessage struct {
+	AppName   string    `json:"appname"`
+	Facility  *uint     `json:"facility"`
+	Hostname  string    `json:"hostname"`
+	Message   string    `json:"message"`
+	Severity  *uint     `json:"severity"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// HTTPResponse is the response of the HTTP listener to a submission. It reports how
+// many of the submitted messages were accepted for processing.
+type HTTPResponse struct {
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Error    string `json:"error,omitempty"`
+}
+
+// listenHTTP serves the HTTP ingestion endpoint on the given HTTP(S) listener until
+// the listener is closed or the http.Server of the listener is shut down
+func (s *Server) listenHTTP(instance *listenerInstance) {
+	defer s.wg.Done()
+	s.log.Info("listening for HTTP requests", slog.String("listener", instance.conf.Name),
+		slog.String("listen_addr", instance.listener.Addr().String()))
+
+	listener := &filterListener{Listener: instance.listener, instance: instance, server: s}
+	err := instance.httpServer.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+		s.log.Error("failed to serve HTTP requests", LogErrKey, err,
+			slog.String("listener", instance.conf.Name))
+	}
+}
+
+// newHTTPServer returns the http.Server for the ingestion endpoint of the given
+// HTTP(S) listener
+func (s *Server) newHTTPServer(instance *listenerInstance) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(instance.conf.HTTPPath, s.httpHandler(instance))
+	return &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: instance.conf.ReadTimeout,
+		IdleTimeout:       instance.conf.IdleTimeout,
+		ReadTimeout:       httpReadTimeout,
+		ErrorLog:          slog.NewLogLogger(s.log.Handler(), slog.LevelError),
+	}
+}
+
+// httpHandler returns the http.Handler for the ingestion endpoint of the given
+// listener. It accepts POST requests that are authenticated with one of the configured
+// bearer tokens. The request body is either a newline-delimited list of raw syslog
+// messages, that are parsed with the parser of the listener, or a stream of JSON
+// objects (Content-Type "application/json" or "application/x-ndjson"), that are
+// mapped to log messages via HTTPMessage.
+func (s *Server) httpHandler(instance *listenerInstance) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.Header().Set("Allow", http.MethodPost)
+			writeHTTPResponse(writer, http.StatusMethodNotAllowed, HTTPResponse{Error: "method not allowed"})
+			return
+		}
+		if !validBearerToken(request, instance.conf.AuthTokens) {
+			s.log.Warn("unauthorized HTTP request", slog.String("listener", instance.conf.Name),
+				slog.String("remote_addr", request.RemoteAddr))
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="logranger"`)
+			writeHTTPResponse(writer, http.StatusUnauthorized, HTTPResponse{Error: "unauthorized"})
+			return
+		}
+
+		meta := map[string]string{MetaInput: "http", MetaRemoteAddr: request.RemoteAddr}
+		if request.TLS != nil {
+			tlsClientMetadata(*request.TLS, meta)
+		}
+		body := http.MaxBytesReader(writer, request.Body, maxHTTPBodySize)
+		mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+
+		var response HTTPResponse
+		var err error
+		switch mediaType {
+		case "application/json", "application/x-ndjson":
+			response, err = s.ingestJSON(instance, body, meta)
+		default:
+			response, err = s.ingestRaw(instance, body, meta)
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			status := http.StatusBadRequest
+			if errors.As(err, &maxBytesErr) || errors.Is(err, ErrMessageTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			response.Error = err.Error()
+			writeHTTPResponse(writer, status, response)
+			return
+		}
+		writeHTTPResponse(writer, http.StatusOK, response)
+	})
+}
+
+// ingestRaw reads newline-delimited raw syslog messages from the given reader, parses
+// them with the parser of the listener and hands them over for processing. If a
+// message exceeds the maximum message size and the listener drops oversize messages,
+// the remainder of the request is rejected.
+func (s *Server) ingestRaw(instance *listenerInstance, body io.Reader, meta map[string]string) (HTTPResponse, error) {
+	response := HTTPResponse{}
+	var remoteAddr net.Addr
+	if addrPort, err := netip.ParseAddrPort(meta[MetaRemoteAddr]); err == nil {
+		remoteAddr = net.TCPAddrFromAddrPort(addrPort)
+	}
+	reader := bufio.NewReader(body)
+	for {
+		frame, err := ReadFrame(reader, FramingLF, int(instance.conf.MaxMessageSize),
+			instance.conf.OversizePolicy)
+		if errors.Is(err, ErrMessageTooLarge) {
+			var keep bool
+			if frame, keep = s.oversizeMessage(instance, meta[MetaRemoteAddr], frame); !keep {
+				return response, err
+			}
+			err = nil
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return response, nil
+			}
+			return response, err
+		}
+		logMessage, err := instance.parser.Parse(frame, remoteAddr)
+		if err != nil {
+			s.log.Error("failed to parse message", LogErrKey, err,
+				slog.String("listener", instance.conf.Name),
+				slog.String("parser_type", instance.conf.Parser))
+			response.Rejected++
+			continue
+		}
+		s.acceptHTTPMessage(instance, logMessage, meta, &response)
+	}
+}
+
+// ingestJSON decodes a stream of JSON objects from the given reader, maps them to
+// log messages and hands them over for processing
+func (s *Server) ingestJSON(instance *listenerInstance, body io.Reader, meta map[string]string) (HTTPResponse, error) {
+	response := HTTPResponse{}
+	decoder := json.NewDecoder(body)
+	for {
+		var httpMessage HTTPMessage
+		if err := decoder.Decode(&httpMessage); err != nil {
+			if errors.Is(err, io.EOF) {
+				return response, nil
+			}
+			return response, err
+		}
+		if httpMessage.Hostname == "" || httpMessage.Message == "" ||
+			(httpMessage.Facility != nil && *httpMessage.Facility > 23) ||
+			(httpMessage.Severity != nil && *httpMessage.Severity > 7) {
+			response.Rejected++
+			continue
+		}
+		s.acceptHTTPMessage(instance, httpMessage.LogMsg(), meta, &response)
+	}
+}
+
+// acceptHTTPMessage hands the given log message over for processing and updates the
+// counters of the given HTTPResponse accordingly
+func (s *Server) acceptHTTPMessage(instance *listenerInstance, logMessage parsesyslog.LogMsg,
+	meta map[string]string, response *HTTPResponse,
+) {
+	if err := s.dispatchMessage(logMessage, meta); err != nil {
+		response.Rejected++
+		return
+	}
+	response.Accepted++
+}
+
+// LogMsg returns the HTTPMessage as parsesyslog.LogMsg. Missing optional fields are
+// set to the facility "user", the severity "informational" and the current time.
+func (m HTTPMessage) LogMsg() parsesyslog.LogMsg {
+	logMessage := parsesyslog.LogMsg{
+		App:       []byte(m.AppName),
+		Facility:  1,
+		Host:      []byte(m.Hostname),
+		Severity:  6,
+		Timestamp: m.Timestamp,
+	}
+	if m.Facility != nil {
+		logMessage.Facility = parsesyslog.Facility(*m.Facility)
+	}
+	if m.Severity != nil {
+		logMessage.Severity = parsesyslog.Severity(*m.Severity)
+	}
+	if logMessage.Timestamp.IsZero() {
+		logMessage.Timestamp = time.Now()
+	}
+	logMessage.Message.WriteString(m.Message)
+	return logMessage
+}
+
+// validBearerToken returns true if the request carries one of the given tokens in
+// its Authorization header. The tokens are compared in constant time.
+func validBearerToken(request *http.Request, tokens []string) bool {
+	scheme, token, ok := strings.Cut(request.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return false
+	}
+	valid := false
+	for _, allowed := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// writeHTTPResponse writes the given HTTPResponse as JSON with the given status code
+func writeHTTPResponse(writer http.ResponseWriter, status int, response HTTPResponse) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(response)
+}
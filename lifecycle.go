@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/wneessen/logranger/plugins"
+)
+
+// initActions calls Init on all actions of the given ruleset that implement the
+// plugins.Lifecycle interface. If an action fails to initialize, the actions that
+// have already been initialized are closed again and an error is returned.
+func (s *Server) initActions(ruleset *Ruleset) error {
+	var initialized []func()
+	for _, rule := range ruleset.Rule {
+		for _, ruleAction := range rule.actions {
+			lifecycle, ok := ruleAction.action.(plugins.Lifecycle)
+			if !ok {
+				continue
+			}
+			if err := lifecycle.Init(); err != nil {
+				for _, closeAction := range initialized {
+					closeAction()
+				}
+				return fmt.Errorf("failed to initialize action %q for rule %q: %w",
+					ruleAction.name, rule.ID, err)
+			}
+			initialized = append(initialized, func() {
+				s.closeAction(rule.ID, ruleAction)
+			})
+		}
+	}
+	return nil
+}
+
+// flushActions calls Flush on all actions of the given ruleset that implement the
+// plugins.Lifecycle interface. Errors are logged.
+func (s *Server) flushActions(ruleset *Ruleset) {
+	for _, rule := range ruleset.Rule {
+		for _, ruleAction := range rule.actions {
+			lifecycle, ok := ruleAction.action.(plugins.Lifecycle)
+			if !ok {
+				continue
+			}
+			if err := lifecycle.Flush(); err != nil {
+				s.log.Error("failed to flush action", LogErrKey, err,
+					slog.String("action", ruleAction.name), slog.String("rule_id", rule.ID))
+			}
+		}
+	}
+}
+
+// closeActions flushes and closes all actions of the given ruleset that implement
+// the plugins.Lifecycle interface. Errors are logged.
+func (s *Server) closeActions(ruleset *Ruleset) {
+	for _, rule := range ruleset.Rule {
+		for _, ruleAction := range rule.actions {
+			s.closeAction(rule.ID, ruleAction)
+		}
+	}
+}
+
+// closeAction flushes and closes the given action of the rule with the given ID, if
+// it implements the plugins.Lifecycle interface. Errors are logged.
+func (s *Server) closeAction(ruleID string, ruleAction ruleAction) {
+	lifecycle, ok := ruleAction.action.(plugins.Lifecycle)
+	if !ok {
+		return
+	}
+	if err := lifecycle.Flush(); err != nil {
+		s.log.Error("failed to flush action", LogErrKey, err,
+			slog.String("action", ruleAction.name), slog.String("rule_id", ruleID))
+	}
+	if err := lifecycle.Close(); err != nil {
+		s.log.Error("failed to close action", LogErrKey, err,
+			slog.String("action", ruleAction.name), slog.String("rule_id", ruleID))
+	}
+}
+
+// flushActionsPeriodically flushes the actions of the active ruleset in the given
+// interval until the Server is shut down
+func (s *Server) flushActionsPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			current := s.acquireRuntime()
+			s.flushActions(current.ruleset)
+			current.release()
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// connLimiter limits the number of concurrent connections of a stream listener, in
+// total and per remote IP address. A limit of zero disables the respective check.
+type connLimiter struct {
+	conns    uint
+	maxConns uint
+	maxPerIP uint
+	mutex    sync.Mutex
+	perIP    map[string]uint
+}
+
+// newConnLimiter returns a new connLimiter with the given limits
+func newConnLimiter(maxConns, maxPerIP uint) *connLimiter {
+	return &connLimiter{
+		maxConns: maxConns,
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]uint),
+	}
+}
+
+// Acquire reserves a slot for a new connection. It returns false if the maximum
+// number of connections has been reached.
+func (l *connLimiter) Acquire() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.maxConns > 0 && l.conns >= l.maxConns {
+		return false
+	}
+	l.conns++
+	return true
+}
+
+// Release frees a slot that has been reserved with Acquire
+func (l *connLimiter) Release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.conns > 0 {
+		l.conns--
+	}
+}
+
+// AcquireAddr reserves a slot for a new connection from the IP address of the given
+// remote address. It returns false if the maximum number of connections per IP
+// address has been reached. Remote addresses without an IP address (e.g. of UNIX
+// socket connections) are not limited.
+func (l *connLimiter) AcquireAddr(addr net.Addr) bool {
+	ip := remoteIP(addr)
+	if l.maxPerIP == 0 || ip == "" {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+	l.perIP[ip]++
+	return true
+}
+
+// ReleaseAddr frees a slot that has been reserved with AcquireAddr
+func (l *connLimiter) ReleaseAddr(addr net.Addr) {
+	ip := remoteIP(addr)
+	if l.maxPerIP == 0 || ip == "" {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+		return
+	}
+	l.perIP[ip]--
+}
+
+// remoteIP returns the IP address of the given remote address as string or an
+// empty string if the address is not an IP-based address
+func remoteIP(addr net.Addr) string {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.String()
+	case *net.UDPAddr:
+		return addr.IP.String()
+	default:
+		return ""
+	}
+}
+
+// logLimiter rate-limits repeated log entries about the same event to at most one
+// per interval and counts the entries that have been suppressed in between.
+type logLimiter struct {
+	interval   time.Duration
+	lastLog    time.Time
+	mutex      sync.Mutex
+	suppressed uint64
+}
+
+// Allow returns true if a log entry may be written, together with the number of log
+// entries that have been suppressed since the last one.
+func (l *logLimiter) Allow() (bool, uint64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if time.Since(l.lastLog) < l.interval {
+		l.suppressed++
+		return false, 0
+	}
+	suppressed := l.suppressed
+	l.lastLog = time.Now()
+	l.suppressed = 0
+	return true, suppressed
+}
@@ -6,9 +6,17 @@ package logranger
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
 	"strings"
+
+	"github.com/wneessen/go-parsesyslog"
 )
 
 // ListenerType is an enumeration wrapper for the different listener types
@@ -21,36 +29,290 @@ const (
 	ListenerTCP
 	// ListenerTLS is a constant of type ListenerType that represents a TLS listener.
 	ListenerTLS
+	// ListenerUDP is a constant of type ListenerType that represents a UDP listener (RFC5426).
+	ListenerUDP
+	// ListenerRELP is a constant of type ListenerType that represents a RELP listener.
+	ListenerRELP
+	// ListenerUnixgram is a constant of type ListenerType that represents a UNIX datagram
+	// socket listener, compatible with /dev/log.
+	ListenerUnixgram
+	// ListenerHTTP is a constant of type ListenerType that represents an HTTP ingestion listener.
+	ListenerHTTP
+	// ListenerHTTPS is a constant of type ListenerType that represents an HTTPS ingestion listener.
+	ListenerHTTPS
+	// ListenerGELFUDP is a constant of type ListenerType that represents a GELF listener
+	// receiving (chunked) datagrams via UDP.
+	ListenerGELFUDP
+	// ListenerGELFTCP is a constant of type ListenerType that represents a GELF listener
+	// receiving null-delimited messages via TCP.
+	ListenerGELFTCP
+)
+
+// TLSClientAuth is an enumeration wrapper for the different client certificate
+// policies of the TLS listener
+type TLSClientAuth uint
+
+const (
+	// TLSClientAuthNone is a constant of type TLSClientAuth that represents a TLS listener
+	// that does not request a client certificate.
+	TLSClientAuthNone TLSClientAuth = iota
+	// TLSClientAuthRequire is a constant of type TLSClientAuth that represents a TLS listener
+	// that requires a valid client certificate.
+	TLSClientAuthRequire
+	// TLSClientAuthVerifyIfGiven is a constant of type TLSClientAuth that represents a TLS
+	// listener that verifies a client certificate only if the client provides one.
+	TLSClientAuthVerifyIfGiven
 )
 
-// NewListener initializes and returns a net.Listener based on the provided
-// configuration. It takes a pointer to a Config struct as a parameter.
+// MaxDatagramSize is the maximum size of a single datagram that is read by a
+// packet-oriented listener. This is the maximum payload size of a UDP datagram
+// as referenced in RFC5426.
+const MaxDatagramSize = 65535
+
+// listenerInstance represents a single listener of the Server. It holds the
+// listener configuration, the parser used for the listener and the opened
+// net.Listener or net.PacketConn, depending on the listener type, as well as the
+// access lists and the connection limits of the listener. GELF UDP listeners
+// additionally hold the assembler for chunked messages, HTTP(S) listeners the
+// http.Server that serves the ingestion endpoint. The socket is the underlying
+// socket of the net.Listener or net.PacketConn, so that it can be handed over to a
+// new listenerInstance with changed settings on reload.
+type listenerInstance struct {
+	access     *accessFilter
+	conf       *ListenerConfig
+	gelfChunks *gelfAssembler
+	httpServer *http.Server
+	limits     *connLimiter
+	listener   net.Listener
+	packetConn net.PacketConn
+	parser     *messageParser
+	socket     socketFile
+}
+
+// socketFile is implemented by the sockets of the net package. File returns a
+// duplicate of the file descriptor of the socket.
+type socketFile interface {
+	File() (*os.File, error)
+}
+
+// newListenerInstance returns a new listenerInstance for the given listener
+// configuration. The listener is not opened yet.
+func newListenerInstance(config *ListenerConfig) (*listenerInstance, error) {
+	parser, err := newMessageParser(config.parserType, parserOptions{
+		facility: parsesyslog.Facility(config.Facility),
+		severity: parsesyslog.Severity(config.Severity),
+		resolve:  config.ResolveHostnames,
+		local:    config.Type == ListenerUnixgram,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize syslog parser for listener %q: %w",
+			config.Name, err)
+	}
+	access, err := newAccessFilter(config.Allow, config.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access lists for listener %q: %w", config.Name, err)
+	}
+	instance := &listenerInstance{
+		access: access,
+		conf:   config,
+		limits: newConnLimiter(config.MaxConnections, config.MaxConnectionsPerIP),
+		parser: parser,
+	}
+	if config.Type == ListenerGELFUDP {
+		instance.gelfChunks = newGELFAssembler()
+	}
+	return instance, nil
+}
+
+// open opens the net.Listener or net.PacketConn for the listenerInstance based on
+// its listener type.
+func (l *listenerInstance) open() error {
+	var err error
+	if l.conf.Type.IsPacketListener() {
+		if l.packetConn, err = NewPacketListener(l.conf); err != nil {
+			return err
+		}
+		l.socket, _ = l.packetConn.(socketFile)
+		return nil
+	}
+	listener, err := listenStream(l.conf)
+	if err != nil {
+		return err
+	}
+	l.socket, _ = listener.(socketFile)
+	l.listener, err = wrapListener(l.conf, listener)
+	return err
+}
+
+// openFile sets up the net.Listener or net.PacketConn for the listenerInstance from
+// an already opened socket, e.g. a socket passed by systemd socket activation. The
+// file is closed afterwards, since the listener holds its own copy of the socket.
+func (l *listenerInstance) openFile(file *os.File) error {
+	defer func() {
+		_ = file.Close()
+	}()
+	var err error
+	if l.conf.Type.IsPacketListener() {
+		if l.packetConn, err = net.FilePacketConn(file); err != nil {
+			return fmt.Errorf("failed to initialize packet listener from socket: %w", err)
+		}
+		l.socket, _ = l.packetConn.(socketFile)
+		if unixConn, ok := l.packetConn.(*net.UnixConn); ok {
+			return enablePassCredentials(unixConn)
+		}
+		return nil
+	}
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return fmt.Errorf("failed to initialize listener from socket: %w", err)
+	}
+	l.socket, _ = listener.(socketFile)
+	l.listener, err = wrapListener(l.conf, listener)
+	return err
+}
+
+// useListener sets the given net.Listener as listener of the listenerInstance. The
+// listener is used as is, it is not wrapped for the PROXY protocol or TLS.
+func (l *listenerInstance) useListener(listener net.Listener) {
+	l.listener = listener
+	l.socket, _ = listener.(socketFile)
+}
+
+// takeOver sets up the net.Listener or net.PacketConn for the listenerInstance from a
+// duplicate of the socket of the given listenerInstance, which is bound to the same
+// address. This allows to change the settings of a listener on reload without
+// closing its socket, so that the previous listener keeps running until the new one
+// is ready.
+func (l *listenerInstance) takeOver(previous *listenerInstance) error {
+	if previous.socket == nil {
+		return errors.New("socket of the previous listener cannot be duplicated")
+	}
+	file, err := previous.socket.File()
+	if err != nil {
+		return fmt.Errorf("failed to duplicate socket of the previous listener: %w", err)
+	}
+	if err = l.openFile(file); err != nil {
+		return err
+	}
+	if l.conf.Type == ListenerUnix || l.conf.Type == ListenerUnixgram {
+		if err = setSocketPermissions(l.conf); err != nil {
+			l.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// keepSocketFile makes sure that the socket file of a UNIX listener is not removed
+// when the listener is closed, because its socket has been taken over by another
+// listenerInstance
+func (l *listenerInstance) keepSocketFile() {
+	if unixListener, ok := l.socket.(*net.UnixListener); ok {
+		unixListener.SetUnlinkOnClose(false)
+	}
+}
+
+// socketAddress returns the network address the socket of a listener with the given
+// configuration is bound to. UNIX stream and datagram sockets share the namespace
+// of the file system.
+func socketAddress(config *ListenerConfig) string {
+	switch {
+	case config.Type == ListenerUnix || config.Type == ListenerUnixgram:
+		return "unix:" + config.Path
+	case config.Type.IsPacketListener():
+		return "udp:" + net.JoinHostPort(config.Addr, strconv.FormatUint(uint64(config.Port), 10))
+	default:
+		return "tcp:" + net.JoinHostPort(config.Addr, strconv.FormatUint(uint64(config.Port), 10))
+	}
+}
+
+// certReloader returns the certReloader of a TLS listener or nil for all other
+// listener types.
+func (l *listenerInstance) certReloader() *certReloader {
+	if listener, ok := l.listener.(*tlsListener); ok {
+		return listener.certs
+	}
+	return nil
+}
+
+// close closes the net.Listener or net.PacketConn of the listenerInstance if it
+// has been opened.
+func (l *listenerInstance) close() {
+	if l.listener != nil {
+		_ = l.listener.Close()
+	}
+	if l.packetConn != nil {
+		_ = l.packetConn.Close()
+	}
+}
+
+// streamListener returns the first stream listener of the given listeners or nil if
+// there is none
+func streamListener(listeners []*listenerInstance) *listenerInstance {
+	for _, instance := range listeners {
+		if !instance.conf.Type.IsPacketListener() {
+			return instance
+		}
+	}
+	return nil
+}
+
+// NewListener initializes and returns a net.Listener for the first stream listener
+// of the provided configuration. It takes a pointer to a Config struct as a parameter.
 // Returns the net.Listener and an error if any occurred during initialization.
+//
+// Deprecated: A Config may hold multiple listeners. Use NewStreamListener with the
+// ListenerConfig of the listener instead.
 func NewListener(config *Config) (net.Listener, error) {
+	listeners := config.Listeners
+	if len(listeners) == 0 {
+		listeners = []ListenerConfig{config.legacyListener()}
+	}
+	for i := range listeners {
+		if !listeners[i].Type.IsPacketListener() {
+			return NewStreamListener(&listeners[i])
+		}
+	}
+	return nil, errors.New("no stream listener configured")
+}
+
+// NewStreamListener initializes and returns a net.Listener based on the provided
+// listener configuration. It takes a pointer to a ListenerConfig struct as a parameter.
+// Returns the net.Listener and an error if any occurred during initialization.
+func NewStreamListener(config *ListenerConfig) (net.Listener, error) {
+	listener, err := listenStream(config)
+	if err != nil {
+		return nil, err
+	}
+	return wrapListener(config, listener)
+}
+
+// listenStream opens the socket of a stream listener based on the provided listener
+// configuration, without wrapping it for the PROXY protocol or TLS.
+func listenStream(config *ListenerConfig) (net.Listener, error) {
 	var listener net.Listener
 	var listenerErr error
-	switch config.Listener.Type {
+	switch config.Type {
 	case ListenerUnix:
-		resolveUnixAddr, err := net.ResolveUnixAddr("unix", config.Listener.ListenerUnix.Path)
+		resolveUnixAddr, err := net.ResolveUnixAddr("unix", config.Path)
 		if err != nil {
 			return nil, fmt.Errorf("failed to resolve UNIX listener socket: %w", err)
 		}
-		listener, listenerErr = net.Listen("unix", resolveUnixAddr.String())
-	case ListenerTCP:
-		listenAddr := net.JoinHostPort(config.Listener.ListenerTCP.Addr,
-			fmt.Sprintf("%d", config.Listener.ListenerTCP.Port))
-		listener, listenerErr = net.Listen("tcp", listenAddr)
-	case ListenerTLS:
-		if config.Listener.ListenerTLS.CertPath == "" || config.Listener.ListenerTLS.KeyPath == "" {
-			return nil, ErrCertConfigEmpty
+		if err = removeStaleSocket(config.Path); err != nil {
+			return nil, err
 		}
-		cert, err := tls.LoadX509KeyPair(config.Listener.ListenerTLS.CertPath, config.Listener.ListenerTLS.KeyPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load X509 certificate: %w", err)
+		listener, listenerErr = net.Listen("unix", resolveUnixAddr.String())
+		if listenerErr == nil {
+			if err = setSocketPermissions(config); err != nil {
+				_ = listener.Close()
+				return nil, err
+			}
 		}
-		listenAddr := net.JoinHostPort(config.Listener.ListenerTLS.Addr, fmt.Sprintf("%d", config.Listener.ListenerTLS.Port))
-		listenConf := &tls.Config{Certificates: []tls.Certificate{cert}}
-		listener, listenerErr = tls.Listen("tcp", listenAddr, listenConf)
+	case ListenerTCP, ListenerTLS, ListenerRELP, ListenerHTTP, ListenerHTTPS, ListenerGELFTCP:
+		listenAddr := net.JoinHostPort(config.Addr, fmt.Sprintf("%d", config.Port))
+		listener, listenerErr = net.Listen("tcp", listenAddr)
+	case ListenerUDP, ListenerUnixgram, ListenerGELFUDP:
+		return nil, fmt.Errorf("failed to initialize listener: %s is a packet listener", config.Type)
 	default:
 		return nil, fmt.Errorf("failed to initialize listener: unknown listener type in config")
 	}
@@ -60,6 +322,311 @@ func NewListener(config *Config) (net.Listener, error) {
 	return listener, nil
 }
 
+// wrapListener wraps the given stream listener based on the provided listener
+// configuration. If the PROXY protocol is enabled, the listener is wrapped into a
+// proxyListener. For TLS and HTTPS listeners, the listener is wrapped into a TLS
+// listener, so that the PROXY protocol header is read before the TLS handshake. If the listener
+// cannot be wrapped, it is closed and an error is returned.
+func wrapListener(config *ListenerConfig, listener net.Listener) (net.Listener, error) {
+	if config.ProxyProtocol {
+		proxyAllow, err := parseCIDRs(config.ProxyAllow)
+		if err != nil {
+			_ = listener.Close()
+			return nil, fmt.Errorf("invalid proxy_allow: %w", err)
+		}
+		listener = newProxyListener(listener, proxyAllow)
+	}
+	if config.Type == ListenerTLS || config.Type == ListenerHTTPS {
+		listenConf, certs, err := newTLSConfig(config)
+		if err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+		listener = &tlsListener{Listener: tls.NewListener(listener, listenConf), certs: certs}
+	}
+	return listener, nil
+}
+
+// parseCIDRs parses the given list of networks in CIDR notation. Single IP addresses
+// are treated as networks with a full-length prefix.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// addrInNetworks returns true if the IP address of the given net.Addr is part of
+// any of the given networks. Addresses without an IP address (e.g. of UNIX sockets)
+// are never part of a network.
+func addrInNetworks(addr net.Addr, networks []*net.IPNet) bool {
+	var ip net.IP
+	switch address := addr.(type) {
+	case *net.TCPAddr:
+		ip = address.IP
+	case *net.UDPAddr:
+		ip = address.IP
+	default:
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewPacketListener initializes and returns a net.PacketConn based on the provided
+// listener configuration. It takes a pointer to a ListenerConfig struct as a parameter.
+// Returns the net.PacketConn and an error if any occurred during initialization.
+func NewPacketListener(config *ListenerConfig) (net.PacketConn, error) {
+	var packetConn net.PacketConn
+	var listenerErr error
+	switch config.Type {
+	case ListenerUDP, ListenerGELFUDP:
+		listenAddr := net.JoinHostPort(config.Addr, fmt.Sprintf("%d", config.Port))
+		packetConn, listenerErr = net.ListenPacket("udp", listenAddr)
+	case ListenerUnixgram:
+		resolveUnixAddr, err := net.ResolveUnixAddr("unixgram", config.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve UNIX datagram listener socket: %w", err)
+		}
+		if err = removeStaleSocket(config.Path); err != nil {
+			return nil, err
+		}
+		var unixConn *net.UnixConn
+		unixConn, listenerErr = net.ListenUnixgram("unixgram", resolveUnixAddr)
+		if listenerErr == nil {
+			packetConn = unixConn
+			if err = setSocketPermissions(config); err != nil {
+				_ = packetConn.Close()
+				return nil, err
+			}
+			if err = enablePassCredentials(unixConn); err != nil {
+				_ = packetConn.Close()
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("failed to initialize packet listener: %s is not a packet listener",
+			config.Type)
+	}
+	if listenerErr != nil {
+		return nil, fmt.Errorf("failed to initialize packet listener: %w", listenerErr)
+	}
+	return packetConn, nil
+}
+
+// removeStaleSocket removes an existing UNIX socket file at the given path, which
+// is left behind by a previous process (e.g. a replaced syslog daemon) and would
+// prevent binding the socket. Files that are not sockets are never removed.
+func removeStaleSocket(path string) error {
+	fileInfo, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to stat UNIX socket path: %w", err)
+	}
+	if fileInfo.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("failed to initialize UNIX socket: %q exists and is not a socket", path)
+	}
+	if err = os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale UNIX socket: %w", err)
+	}
+	return nil
+}
+
+// setSocketPermissions applies the file mode, owner and group configured for a
+// UNIX socket listener to the socket file. Settings that are not configured are
+// left untouched. Owner and group can be given as name or as numeric ID.
+func setSocketPermissions(config *ListenerConfig) error {
+	if config.Mode != "" {
+		mode, err := strconv.ParseUint(config.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid socket mode %q: %w", config.Mode, err)
+		}
+		if err = os.Chmod(config.Path, os.FileMode(mode)&os.ModePerm); err != nil {
+			return fmt.Errorf("failed to set socket mode: %w", err)
+		}
+	}
+	if config.Owner == "" && config.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if config.Owner != "" {
+		owner, err := user.Lookup(config.Owner)
+		if err != nil {
+			owner, err = user.LookupId(config.Owner)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up socket owner %q: %w", config.Owner, err)
+		}
+		if uid, err = strconv.Atoi(owner.Uid); err != nil {
+			return fmt.Errorf("invalid UID for socket owner %q: %w", config.Owner, err)
+		}
+	}
+	if config.Group != "" {
+		group, err := user.LookupGroup(config.Group)
+		if err != nil {
+			group, err = user.LookupGroupId(config.Group)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up socket group %q: %w", config.Group, err)
+		}
+		if gid, err = strconv.Atoi(group.Gid); err != nil {
+			return fmt.Errorf("invalid GID for socket group %q: %w", config.Group, err)
+		}
+	}
+	if err := os.Chown(config.Path, uid, gid); err != nil {
+		return fmt.Errorf("failed to set socket owner: %w", err)
+	}
+	return nil
+}
+
+// newTLSConfig returns the tls.Config for the TLS listener based on the provided
+// configuration. If a client CA is configured, client certificates are verified
+// against it according to the configured client_auth policy, which defaults to
+// requiring a client certificate. If an allowlist of client identities is configured,
+// the subject CN or one of the SANs of a client certificate must match an entry of
+// the allowlist.
+// The server certificate is served by the returned certReloader, so that it can be
+// replaced without restarting the listener.
+func newTLSConfig(tlsConf *ListenerConfig) (*tls.Config, *certReloader, error) {
+	if tlsConf.CertPath == "" || tlsConf.KeyPath == "" {
+		return nil, nil, ErrCertConfigEmpty
+	}
+	minVersion, err := tlsVersionFromString(tlsConf.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	cipherSuites, err := cipherSuitesFromNames(tlsConf.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+	curves, err := curvesFromNames(tlsConf.CurvePreferences)
+	if err != nil {
+		return nil, nil, err
+	}
+	certs, err := newCertReloader(tlsConf.CertPath, tlsConf.KeyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	listenConf := &tls.Config{
+		GetCertificate:   certs.GetCertificate,
+		MinVersion:       minVersion,
+		CipherSuites:     cipherSuites,
+		CurvePreferences: curves,
+	}
+
+	clientAuth := tlsConf.ClientAuth
+	if tlsConf.ClientCAPath != "" && clientAuth == TLSClientAuthNone {
+		clientAuth = TLSClientAuthRequire
+	}
+	if clientAuth == TLSClientAuthNone {
+		if len(tlsConf.ClientAllow) > 0 {
+			return nil, nil, ErrClientCAEmpty
+		}
+		return listenConf, certs, nil
+	}
+	if tlsConf.ClientCAPath == "" {
+		return nil, nil, ErrClientCAEmpty
+	}
+	caPEM, err := os.ReadFile(tlsConf.ClientCAPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, nil, fmt.Errorf("failed to load client CA: no valid certificates found in %q",
+			tlsConf.ClientCAPath)
+	}
+	listenConf.ClientCAs = clientCAs
+	listenConf.ClientAuth = tls.RequireAndVerifyClientCert
+	if clientAuth == TLSClientAuthVerifyIfGiven {
+		listenConf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if len(tlsConf.ClientAllow) > 0 {
+		listenConf.VerifyConnection = verifyClientIdentity(tlsConf.ClientAllow)
+	}
+	return listenConf, certs, nil
+}
+
+// verifyClientIdentity returns a function that satisfies the VerifyConnection field
+// of tls.Config and checks the verified client certificate against the given allowlist
+// of subject CNs and SANs. Connections without a client certificate are not checked,
+// since the client_auth policy already decides if a certificate is required.
+func verifyClientIdentity(allowlist []string) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return nil
+		}
+		clientCert := state.PeerCertificates[0]
+		for _, identity := range certIdentities(clientCert) {
+			for _, allowed := range allowlist {
+				if strings.EqualFold(identity, allowed) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%w: %s", ErrClientNotAllowed, clientCert.Subject.String())
+	}
+}
+
+// certIdentities returns the subject CN and all SANs of the given certificate.
+func certIdentities(cert *x509.Certificate) []string {
+	identities := make([]string, 0)
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	return append(identities, certSANs(cert)...)
+}
+
+// certSANs returns all SANs (DNS names, email addresses, IP addresses and URIs)
+// of the given certificate.
+func certSANs(cert *x509.Certificate) []string {
+	sans := make([]string, 0)
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+// IsHTTPListener returns true if the ListenerType is an HTTP(S) ingestion listener
+// that is served by listenHTTP instead of accepting raw connections.
+func (l ListenerType) IsHTTPListener() bool {
+	return l == ListenerHTTP || l == ListenerHTTPS
+}
+
+// IsPacketListener returns true if the ListenerType is a packet-oriented listener
+// that needs to be initialized with NewPacketListener instead of NewStreamListener.
+func (l ListenerType) IsPacketListener() bool {
+	return l == ListenerUDP || l == ListenerUnixgram || l == ListenerGELFUDP
+}
+
 // UnmarshalString satisfies the fig.StringUnmarshaler interface for the ListenerType type
 func (l *ListenerType) UnmarshalString(value string) error {
 	switch strings.ToLower(value) {
@@ -69,12 +636,41 @@ func (l *ListenerType) UnmarshalString(value string) error {
 		*l = ListenerTCP
 	case "tls":
 		*l = ListenerTLS
+	case "udp":
+		*l = ListenerUDP
+	case "relp":
+		*l = ListenerRELP
+	case "unixgram":
+		*l = ListenerUnixgram
+	case "http":
+		*l = ListenerHTTP
+	case "https":
+		*l = ListenerHTTPS
+	case "gelf_udp":
+		*l = ListenerGELFUDP
+	case "gelf_tcp":
+		*l = ListenerGELFTCP
 	default:
 		return fmt.Errorf("unknown listener type: %s", value)
 	}
 	return nil
 }
 
+// UnmarshalString satisfies the fig.StringUnmarshaler interface for the TLSClientAuth type
+func (c *TLSClientAuth) UnmarshalString(value string) error {
+	switch strings.ToLower(value) {
+	case "none", "":
+		*c = TLSClientAuthNone
+	case "require":
+		*c = TLSClientAuthRequire
+	case "verify_if_given":
+		*c = TLSClientAuthVerifyIfGiven
+	default:
+		return fmt.Errorf("unknown TLS client auth policy: %s", value)
+	}
+	return nil
+}
+
 // String satisfies the fmt.Stringer interface for the ListenerType type
 func (l ListenerType) String() string {
 	switch l {
@@ -84,6 +680,20 @@ func (l ListenerType) String() string {
 		return "TCP listener"
 	case ListenerTLS:
 		return "TLS listener"
+	case ListenerUDP:
+		return "UDP listener"
+	case ListenerRELP:
+		return "RELP listener"
+	case ListenerUnixgram:
+		return "UNIX datagram listener"
+	case ListenerHTTP:
+		return "HTTP listener"
+	case ListenerHTTPS:
+		return "HTTPS listener"
+	case ListenerGELFUDP:
+		return "GELF UDP listener"
+	case ListenerGELFTCP:
+		return "GELF TCP listener"
 	default:
 		return "Unknown listener type"
 	}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+// Package metadata provides access to the metadata that logranger attaches to a
+// log message on the receiving side, like the verified identity of a TLS client.
+//
+// The metadata is stored as a structured data element with the ID SDID in the
+// log message, so that it is passed on to the action plugins and templates along
+// with the log message itself.
+package metadata
+
+import (
+	"github.com/wneessen/go-parsesyslog"
+)
+
+// SDID is the structured data ID under which logranger stores its metadata in a
+// log message
+const SDID = "logranger"
+
+// Set sets the metadata with the given key to the given value on the log message.
+// An existing value for the same key is replaced.
+func Set(logMessage *parsesyslog.LogMsg, key, value string) {
+	for i := range logMessage.StructuredData {
+		if logMessage.StructuredData[i].IDString() != SDID {
+			continue
+		}
+		for j := range logMessage.StructuredData[i].Param {
+			if logMessage.StructuredData[i].Param[j].Name() == key {
+				logMessage.StructuredData[i].Param[j].Val = []byte(value)
+				return
+			}
+		}
+		logMessage.StructuredData[i].Param = append(logMessage.StructuredData[i].Param,
+			parsesyslog.StructuredDataParam{Key: []byte(key), Val: []byte(value)})
+		return
+	}
+	logMessage.StructuredData = append(logMessage.StructuredData, parsesyslog.StructuredDataElement{
+		ID:    []byte(SDID),
+		Param: []parsesyslog.StructuredDataParam{{Key: []byte(key), Val: []byte(value)}},
+	})
+}
+
+// Get returns the metadata value for the given key of the log message and true if
+// the key is present. If the key is not present, an empty string and false are
+// returned.
+func Get(logMessage parsesyslog.LogMsg, key string) (string, bool) {
+	for _, element := range logMessage.StructuredData {
+		if element.IDString() != SDID {
+			continue
+		}
+		for _, param := range element.Param {
+			if param.Name() == key {
+				return param.Value(), true
+			}
+		}
+	}
+	return "", false
+}
+
+// Map returns all metadata of the log message as a map of keys to values
+func Map(logMessage parsesyslog.LogMsg) map[string]string {
+	metaMap := make(map[string]string)
+	for _, element := range logMessage.StructuredData {
+		if element.IDString() != SDID {
+			continue
+		}
+		for _, param := range element.Param {
+			metaMap[param.Name()] = param.Value()
+		}
+	}
+	return metaMap
+}
+
+// Strip removes all logranger metadata from the log message. It is used to make
+// sure that a sender cannot inject metadata via the structured data of a message.
+func Strip(logMessage *parsesyslog.LogMsg) {
+	if len(logMessage.StructuredData) == 0 {
+		return
+	}
+	elements := logMessage.StructuredData[:0]
+	for _, element := range logMessage.StructuredData {
+		if element.IDString() != SDID {
+			elements = append(elements, element)
+		}
+	}
+	logMessage.StructuredData = elements
+}
@@ -0,0 +1,319 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wneessen/go-parsesyslog"
+	"github.com/wneessen/go-parsesyslog/rfc3164"
+	"github.com/wneessen/go-parsesyslog/rfc5424"
+
+	"github.com/wneessen/logranger/metadata"
+)
+
+// ParserAuto is the parser type that detects the format of each message and
+// dispatches it to either the RFC3164 or the RFC5424 parser
+const ParserAuto parsesyslog.ParserType = "auto"
+
+// ParserRaw is the parser type that accepts any line as message body without
+// expecting a syslog header
+const ParserRaw parsesyslog.ParserType = "raw"
+
+const (
+	// hostnameCacheTTL is the duration for which a reverse-resolved hostname is cached
+	hostnameCacheTTL = 5 * time.Minute
+	// hostnameCacheSize is the maximum number of cached reverse-resolved hostnames
+	hostnameCacheSize = 4096
+	// hostnameLookupTimeout is the maximum duration of a single reverse DNS lookup
+	hostnameLookupTimeout = 2 * time.Second
+	// hostnameMaxLookups is the maximum number of concurrent reverse DNS lookups
+	hostnameMaxLookups = 64
+)
+
+// MetaParserType is the metadata key under which the type of the parser that was
+// used for a message is stored
+const MetaParserType = "parser_type"
+
+// messageParser parses single message frames with the parser of the configured
+// type. For ParserAuto, the parser is selected for each message individually. The
+// parsers of the parsesyslog package keep state while parsing a message, so each
+// parser type has a pool of parsers, from which every Parse call takes its own.
+// Since the parsers reuse their buffers for the next message, the parsed log message
+// is copied before the parser is put back into the pool.
+// A messageParser can therefore be shared by all connections of a listener.
+type messageParser struct {
+	parserType parsesyslog.ParserType
+	parsers    map[parsesyslog.ParserType]*sync.Pool
+	options    parserOptions
+	resolver   *hostnameResolver
+}
+
+// parserOptions holds the settings of a messageParser. Since raw messages carry no
+// syslog header, the facility and severity of raw messages are set to the configured
+// defaults. Messages received on the local syslog socket carry no HOSTNAME, so the
+// local hostname is inserted into them if local is set.
+type parserOptions struct {
+	facility parsesyslog.Facility
+	severity parsesyslog.Severity
+	resolve  bool
+	local    bool
+}
+
+// hostnameResolver determines the hostname of raw messages from the remote address
+// of the sender. Reverse DNS lookups are performed in the background, so that they
+// do not block the listener, and their results are cached for hostnameCacheTTL.
+type hostnameResolver struct {
+	cache         map[string]cachedHostname
+	localHostname string
+	mutex         sync.Mutex
+	pending       map[string]struct{}
+	resolve       bool
+}
+
+// cachedHostname is a reverse-resolved hostname and its expiry time
+type cachedHostname struct {
+	expires  time.Time
+	hostname string
+}
+
+// newMessageParser returns a messageParser for the given parser type. For ParserAuto,
+// both the RFC3164 and the RFC5424 parsers are initialized.
+func newMessageParser(parserType parsesyslog.ParserType, options parserOptions) (*messageParser, error) {
+	parserTypes := []parsesyslog.ParserType{parserType}
+	switch parserType {
+	case ParserAuto:
+		parserTypes = []parsesyslog.ParserType{rfc3164.Type, rfc5424.Type}
+	case ParserRaw:
+		parserTypes = nil
+	}
+	messageParser := &messageParser{
+		parserType: parserType,
+		parsers:    make(map[parsesyslog.ParserType]*sync.Pool),
+		options:    options,
+	}
+	if parserType == ParserRaw || options.local {
+		resolver, err := newHostnameResolver(options.resolve)
+		if err != nil {
+			return nil, err
+		}
+		messageParser.resolver = resolver
+	}
+	for _, parserType := range parserTypes {
+		parser, err := parsesyslog.New(parserType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize %s parser: %w", parserType, err)
+		}
+		pool := &sync.Pool{}
+		pool.Put(parser)
+		messageParser.parsers[parserType] = pool
+	}
+	return messageParser, nil
+}
+
+// Parse parses the given message frame and returns the resulting log message. Any
+// logranger metadata that the sender might have included in the structured data of
+// the message is removed, and the type of the parser that was used is stored in the
+// metadata of the log message. The given remote address of the sender is only used
+// by the ParserRaw type and may be nil. On the local syslog socket, the local hostname
+// is inserted into RFC3164 messages before parsing them.
+func (p *messageParser) Parse(frame []byte, remoteAddr net.Addr) (parsesyslog.LogMsg, error) {
+	parserType := p.parserType
+	if parserType == ParserAuto {
+		parserType = DetectParserType(frame)
+	}
+	if parserType == ParserRaw {
+		return p.parseRaw(frame, remoteAddr), nil
+	}
+	if p.options.local && parserType == rfc3164.Type {
+		frame = localFrame(frame, p.resolver.localHostname)
+	}
+	parser, err := p.parser(parserType)
+	if err != nil {
+		return parsesyslog.LogMsg{}, fmt.Errorf("failed to initialize %s parser: %w", parserType, err)
+	}
+	logMessage, err := parser.ParseReader(frameReader(parserType, frame))
+	cloneLogMessage(&logMessage)
+	p.parsers[parserType].Put(parser)
+	if err != nil {
+		return logMessage, fmt.Errorf("%s parser: %w", parserType, err)
+	}
+	metadata.Strip(&logMessage)
+	metadata.Set(&logMessage, MetaParserType, string(parserType))
+	return logMessage, nil
+}
+
+// parser takes a parser of the given type from its pool or creates a new one if the
+// pool is empty. The parser must be put back into the pool after use.
+func (p *messageParser) parser(parserType parsesyslog.ParserType) (parsesyslog.Parser, error) {
+	if parser, ok := p.parsers[parserType].Get().(parsesyslog.Parser); ok {
+		return parser, nil
+	}
+	return parsesyslog.New(parserType)
+}
+
+// cloneLogMessage replaces the header fields and the structured data of the given
+// log message with copies. The parsers of the parsesyslog package return them as
+// slices of their internal buffers, which are overwritten by the next message.
+func cloneLogMessage(logMessage *parsesyslog.LogMsg) {
+	logMessage.App = bytes.Clone(logMessage.App)
+	logMessage.Host = bytes.Clone(logMessage.Host)
+	logMessage.MsgID = bytes.Clone(logMessage.MsgID)
+	logMessage.PID = bytes.Clone(logMessage.PID)
+	if logMessage.StructuredData == nil {
+		return
+	}
+	elements := make([]parsesyslog.StructuredDataElement, len(logMessage.StructuredData))
+	for i, element := range logMessage.StructuredData {
+		params := make([]parsesyslog.StructuredDataParam, len(element.Param))
+		for j, param := range element.Param {
+			params[j] = parsesyslog.StructuredDataParam{Key: bytes.Clone(param.Key), Val: bytes.Clone(param.Val)}
+		}
+		elements[i] = parsesyslog.StructuredDataElement{ID: bytes.Clone(element.ID), Param: params}
+	}
+	logMessage.StructuredData = elements
+}
+
+// parseRaw returns the given message frame as log message without interpreting it.
+// The hostname is determined from the remote address of the sender, the facility and
+// severity are set to the configured defaults and the timestamp is set to the time
+// of receipt.
+func (p *messageParser) parseRaw(frame []byte, remoteAddr net.Addr) parsesyslog.LogMsg {
+	frame = bytes.TrimRight(frame, "\r\n\x00")
+	logMessage := parsesyslog.LogMsg{
+		Facility:  p.options.facility,
+		Host:      []byte(p.resolver.Hostname(remoteAddr)),
+		MsgLength: int32(len(frame)),
+		Severity:  p.options.severity,
+		Timestamp: time.Now(),
+	}
+	logMessage.Message.Write(frame)
+	metadata.Set(&logMessage, MetaParserType, string(ParserRaw))
+	return logMessage
+}
+
+// localFrame inserts the given hostname after the timestamp of the given RFC3164
+// message frame. The syslog(3) function of the C library sends messages of the form
+// "<PRI>TIMESTAMP TAG[PID]: MSG" without a HOSTNAME to the local syslog socket, for
+// which the RFC3164 parser would take the TAG as the hostname. Frames that do not
+// start with a PRI part and a timestamp are returned unchanged.
+func localFrame(frame []byte, hostname string) []byte {
+	priEnd := bytes.IndexByte(frame, '>')
+	if len(frame) == 0 || frame[0] != '<' || priEnd < 2 || priEnd > 4 {
+		return frame
+	}
+	timestampEnd := priEnd + 1 + len(time.Stamp)
+	if len(frame) <= timestampEnd || frame[timestampEnd] != ' ' {
+		return frame
+	}
+	if _, err := time.Parse(time.Stamp, string(frame[priEnd+1:timestampEnd])); err != nil {
+		return frame
+	}
+	local := make([]byte, 0, len(frame)+len(hostname)+1)
+	local = append(local, frame[:timestampEnd+1]...)
+	local = append(local, hostname...)
+	local = append(local, ' ')
+	return append(local, frame[timestampEnd+1:]...)
+}
+
+// newHostnameResolver returns a new hostnameResolver. If resolve is true, the IP
+// addresses of senders are reverse-resolved to hostnames.
+func newHostnameResolver(resolve bool) (*hostnameResolver, error) {
+	localHostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine local hostname: %w", err)
+	}
+	return &hostnameResolver{
+		cache:         make(map[string]cachedHostname),
+		localHostname: localHostname,
+		pending:       make(map[string]struct{}),
+		resolve:       resolve,
+	}, nil
+}
+
+// Hostname returns the hostname for the given remote address. If reverse resolution
+// is disabled, the IP address is returned. Otherwise, the cached hostname is returned.
+// If no hostname has been cached yet or the cached hostname has expired, a lookup is
+// started in the background and the IP address or the expired hostname is returned
+// in the meantime. Senders without an IP address, e.g. on UNIX sockets or standard
+// input, are local, so the local hostname is returned for them.
+func (r *hostnameResolver) Hostname(remoteAddr net.Addr) string {
+	ip := remoteIP(remoteAddr)
+	if ip == "" {
+		return r.localHostname
+	}
+	if !r.resolve {
+		return ip
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	hostname := ip
+	cached, ok := r.cache[ip]
+	if ok {
+		hostname = cached.hostname
+	}
+	if ok && time.Now().Before(cached.expires) {
+		return hostname
+	}
+	if _, ok = r.pending[ip]; !ok && len(r.pending) < hostnameMaxLookups {
+		r.pending[ip] = struct{}{}
+		go r.lookup(ip)
+	}
+	return hostname
+}
+
+// lookup reverse-resolves the given IP address and caches the resulting hostname. If
+// the lookup fails, the IP address is cached instead, so that unresolvable senders are
+// not looked up again before hostnameCacheTTL has passed.
+func (r *hostnameResolver) lookup(ip string) {
+	hostname := ip
+	ctx, cancel := context.WithTimeout(context.Background(), hostnameLookupTimeout)
+	defer cancel()
+	if names, err := net.DefaultResolver.LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+		hostname = strings.TrimSuffix(names[0], ".")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.pending, ip)
+	if len(r.cache) >= hostnameCacheSize {
+		clear(r.cache)
+	}
+	r.cache[ip] = cachedHostname{expires: time.Now().Add(hostnameCacheTTL), hostname: hostname}
+}
+
+// DetectParserType inspects the header of the given message frame and returns the
+// parser type that is suitable for it. RFC5424 messages are identified by the
+// version number that directly follows the PRI part ("<PRI>VERSION SP"). All other
+// messages are treated as RFC3164 messages.
+func DetectParserType(frame []byte) parsesyslog.ParserType {
+	if len(frame) == 0 || frame[0] != '<' {
+		return rfc3164.Type
+	}
+	pos := 1
+	for pos < len(frame) && pos <= 3 && frame[pos] >= '0' && frame[pos] <= '9' {
+		pos++
+	}
+	if pos == 1 || pos >= len(frame) || frame[pos] != '>' {
+		return rfc3164.Type
+	}
+	pos++
+	versionStart := pos
+	for pos < len(frame) && pos-versionStart < 2 && frame[pos] >= '0' && frame[pos] <= '9' {
+		pos++
+	}
+	if pos == versionStart || frame[versionStart] == '0' || pos >= len(frame) || frame[pos] != ' ' {
+		return rfc3164.Type
+	}
+	return rfc5424.Type
+}
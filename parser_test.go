@@ -0,0 +1,270 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wneessen/go-parsesyslog"
+	"github.com/wneessen/go-parsesyslog/rfc3164"
+	"github.com/wneessen/go-parsesyslog/rfc5424"
+
+	"github.com/wneessen/logranger/metadata"
+)
+
+const (
+	testRFC3164Message = "<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8"
+	testRFC5424Message = "<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed"
+)
+
+func TestDetectParserType(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		want  parsesyslog.ParserType
+	}{
+		{"RFC5424 message", testRFC5424Message, rfc5424.Type},
+		{"RFC5424 message with two digit version", "<165>12 - - - - - -", rfc5424.Type},
+		{"RFC5424 message with minimal PRI", "<0>1 - - - - - -", rfc5424.Type},
+		{"RFC3164 message", testRFC3164Message, rfc3164.Type},
+		{
+			"ambiguous RFC3164 message with a digit after the PRI",
+			"<13>1 Oct 11 22:14:15 mymachine su: failed", rfc5424.Type,
+		},
+		{"empty frame", "", rfc3164.Type},
+		{"missing PRI", "Oct 11 22:14:15 mymachine su: failed", rfc3164.Type},
+		{"empty PRI", "<>1 - - - - - -", rfc3164.Type},
+		{"PRI exceeding three digits", "<1234>1 - - - - - -", rfc3164.Type},
+		{"unterminated PRI", "<34", rfc3164.Type},
+		{"non-numeric PRI", "<ab>1 - - - - - -", rfc3164.Type},
+		{"version zero", "<34>0 - - - - - -", rfc3164.Type},
+		{"version exceeding two digits", "<34>100 - - - - - -", rfc3164.Type},
+		{"version without trailing space", "<34>1", rfc3164.Type},
+		{"PRI followed by space", "<34> 1 - - - - - -", rfc3164.Type},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectParserType([]byte(tt.frame)); got != tt.want {
+				t.Errorf("DetectParserType(%q) = %s, want %s", tt.frame, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageParser_Parse(t *testing.T) {
+	remoteAddr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 514}
+	tests := []struct {
+		name       string
+		parserType parsesyslog.ParserType
+		frame      string
+		wantType   parsesyslog.ParserType
+		wantHost   string
+		wantApp    string
+		wantErr    bool
+	}{
+		{"auto parser with RFC3164 message", ParserAuto, testRFC3164Message, rfc3164.Type, "mymachine", "su", false},
+		{
+			"auto parser with RFC5424 message", ParserAuto, testRFC5424Message, rfc5424.Type,
+			"mymachine.example.com", "su", false,
+		},
+		{"RFC3164 parser", rfc3164.Type, testRFC3164Message + "\n", rfc3164.Type, "mymachine", "su", false},
+		{
+			"RFC5424 parser", rfc5424.Type, testRFC5424Message, rfc5424.Type,
+			"mymachine.example.com", "su", false,
+		},
+		{"raw parser", ParserRaw, "12:00:01 service started\r\n", ParserRaw, "192.0.2.1", "", false},
+		{"RFC5424 parser with invalid message", rfc5424.Type, "<34>1 invalid", rfc5424.Type, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := newMessageParser(tt.parserType, parserOptions{facility: 1, severity: 5})
+			if err != nil {
+				t.Fatalf("failed to create message parser: %s", err)
+			}
+			logMessage, err := parser.Parse([]byte(tt.frame), remoteAddr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse returned error %v, want error: %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if parserType, _ := metadata.Get(logMessage, MetaParserType); parserType != string(tt.wantType) {
+				t.Errorf("parser type = %q, want %q", parserType, tt.wantType)
+			}
+			if logMessage.Hostname() != tt.wantHost {
+				t.Errorf("hostname = %q, want %q", logMessage.Hostname(), tt.wantHost)
+			}
+			if logMessage.AppName() != tt.wantApp {
+				t.Errorf("app name = %q, want %q", logMessage.AppName(), tt.wantApp)
+			}
+		})
+	}
+}
+
+func TestMessageParser_Parse_local(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("failed to determine local hostname: %s", err)
+	}
+	tests := []struct {
+		name     string
+		frame    string
+		wantHost string
+		wantApp  string
+		wantPID  string
+	}{
+		{"syslog(3) message with PID", "<13>Oct 16 12:00:00 myapp[123]: hello world", hostname, "myapp", "123"},
+		{"syslog(3) message without PID", "<13>Oct  6 12:00:00 myapp: hello world", hostname, "myapp", ""},
+		{"RFC5424 message", testRFC5424Message, "mymachine.example.com", "su", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := newMessageParser(ParserAuto, parserOptions{local: true})
+			if err != nil {
+				t.Fatalf("failed to create message parser: %s", err)
+			}
+			logMessage, err := parser.Parse([]byte(tt.frame), nil)
+			if err != nil {
+				t.Fatalf("failed to parse message: %s", err)
+			}
+			if logMessage.Hostname() != tt.wantHost {
+				t.Errorf("hostname = %q, want %q", logMessage.Hostname(), tt.wantHost)
+			}
+			if logMessage.AppName() != tt.wantApp {
+				t.Errorf("app name = %q, want %q", logMessage.AppName(), tt.wantApp)
+			}
+			if logMessage.ProcID() != tt.wantPID {
+				t.Errorf("proc ID = %q, want %q", logMessage.ProcID(), tt.wantPID)
+			}
+		})
+	}
+}
+
+// TestMessageParser_Parse_concurrent makes sure that a messageParser can be shared by
+// concurrent connections and that the returned log messages are not modified by later
+// Parse calls. It is meant to be run with the race detector.
+func TestMessageParser_Parse_concurrent(t *testing.T) {
+	parser, err := newMessageParser(ParserAuto, parserOptions{})
+	if err != nil {
+		t.Fatalf("failed to create message parser: %s", err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	results := make([][]parsesyslog.LogMsg, cap(errs))
+	for i := 0; i < cap(errs); i++ {
+		frame := fmt.Sprintf("<34>Oct 11 22:14:15 host%d app%d[%d]: failed", i, i, i)
+		if i%2 == 0 {
+			frame = fmt.Sprintf("<34>1 2003-10-11T22:14:15.003Z host%d app%d %d ID%d - failed", i, i, i, i)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logMessage, err := parser.Parse([]byte(frame), nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+				results[i] = append(results[i], logMessage)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent Parse failed: %s", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err = parser.Parse([]byte(testRFC5424Message), nil); err != nil {
+			t.Fatalf("failed to parse message: %s", err)
+		}
+		if _, err = parser.Parse([]byte(testRFC3164Message), nil); err != nil {
+			t.Fatalf("failed to parse message: %s", err)
+		}
+	}
+	for i, logMessages := range results {
+		wantMsgID := ""
+		if i%2 == 0 {
+			wantMsgID = fmt.Sprintf("ID%d", i)
+		}
+		for _, logMessage := range logMessages {
+			if want := fmt.Sprintf("host%d", i); logMessage.Hostname() != want {
+				t.Errorf("hostname = %q, want %q", logMessage.Hostname(), want)
+			}
+			if want := fmt.Sprintf("app%d", i); logMessage.AppName() != want {
+				t.Errorf("app name = %q, want %q", logMessage.AppName(), want)
+			}
+			if want := fmt.Sprintf("%d", i); logMessage.ProcID() != want {
+				t.Errorf("proc ID = %q, want %q", logMessage.ProcID(), want)
+			}
+			if string(logMessage.MsgID) != wantMsgID {
+				t.Errorf("message ID = %q, want %q", logMessage.MsgID, wantMsgID)
+			}
+		}
+	}
+}
+
+func TestHostnameResolver_Hostname(t *testing.T) {
+	resolver, err := newHostnameResolver(true)
+	if err != nil {
+		t.Fatalf("failed to create hostname resolver: %s", err)
+	}
+	remoteAddr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 514}
+	if hostname := resolver.Hostname(nil); hostname != resolver.localHostname {
+		t.Errorf("hostname of local sender = %q, want %q", hostname, resolver.localHostname)
+	}
+
+	resolver.mutex.Lock()
+	resolver.cache["192.0.2.1"] = cachedHostname{expires: time.Now().Add(time.Minute), hostname: "cached.example.com"}
+	resolver.mutex.Unlock()
+	if hostname := resolver.Hostname(remoteAddr); hostname != "cached.example.com" {
+		t.Errorf("hostname of cached sender = %q, want %q", hostname, "cached.example.com")
+	}
+
+	resolver.mutex.Lock()
+	resolver.cache["192.0.2.1"] = cachedHostname{expires: time.Now().Add(-time.Minute), hostname: "expired.example.com"}
+	resolver.mutex.Unlock()
+	if hostname := resolver.Hostname(remoteAddr); hostname != "expired.example.com" {
+		t.Errorf("hostname of expired sender = %q, want %q", hostname, "expired.example.com")
+	}
+	waitForLookups(t, resolver)
+
+	resolver.mutex.Lock()
+	clear(resolver.cache)
+	resolver.mutex.Unlock()
+	if hostname := resolver.Hostname(remoteAddr); hostname != "192.0.2.1" {
+		t.Errorf("hostname of unresolved sender = %q, want %q", hostname, "192.0.2.1")
+	}
+	waitForLookups(t, resolver)
+	resolver.mutex.Lock()
+	cached, ok := resolver.cache["192.0.2.1"]
+	resolver.mutex.Unlock()
+	if !ok || !time.Now().Before(cached.expires) {
+		t.Errorf("lookup result has not been cached")
+	}
+}
+
+// waitForLookups waits until the background lookups of the given hostnameResolver
+// have finished
+func waitForLookups(t *testing.T, resolver *hostnameResolver) {
+	t.Helper()
+	deadline := time.Now().Add(hostnameLookupTimeout + time.Second)
+	for time.Now().Before(deadline) {
+		resolver.mutex.Lock()
+		pending := len(resolver.pending)
+		resolver.mutex.Unlock()
+		if pending == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("background lookups did not finish in time")
+}
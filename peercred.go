@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MetaPeerPID is the metadata key under which the PID of the process that sent a
+	// message via a UNIX socket is stored
+	MetaPeerPID = "peer_pid"
+	// MetaPeerUID is the metadata key under which the UID of the process that sent a
+	// message via a UNIX socket is stored
+	MetaPeerUID = "peer_uid"
+	// MetaPeerGID is the metadata key under which the GID of the process that sent a
+	// message via a UNIX socket is stored
+	MetaPeerGID = "peer_gid"
+	// MetaPeerExe is the metadata key under which the executable of the process that
+	// sent a message via a UNIX socket is stored
+	MetaPeerExe = "peer_exe"
+)
+
+// peerCredentials holds the credentials of the process on the other end of a UNIX
+// socket, as reported by the kernel
+type peerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// setMetadata stores the peer credentials and the executable of the peer process in
+// the given metadata map
+func (c peerCredentials) setMetadata(meta map[string]string) {
+	meta[MetaPeerPID] = strconv.FormatInt(int64(c.PID), 10)
+	meta[MetaPeerUID] = strconv.FormatUint(uint64(c.UID), 10)
+	meta[MetaPeerGID] = strconv.FormatUint(uint64(c.GID), 10)
+	if exe := processExecutable(c.PID); exe != "" {
+		meta[MetaPeerExe] = exe
+	}
+}
+
+// processExecutable returns the path of the executable of the process with the given
+// PID from /proc. Reading the executable link of processes of other users requires
+// privileges, in which case the command name of the process is returned instead. If
+// neither is available, e.g. because the process has already exited, an empty string
+// is returned.
+func processExecutable(pid int32) string {
+	if pid <= 0 {
+		return ""
+	}
+	if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil {
+		return exe
+	}
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
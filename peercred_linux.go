@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+//go:build linux
+
+package logranger
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// credentialsOOBSize is the size of the out-of-band buffer required to receive the
+// SCM_CREDENTIALS control message of a datagram
+var credentialsOOBSize = syscall.CmsgSpace(syscall.SizeofUcred)
+
+// connPeerCredentials returns the credentials of the peer process of the given UNIX
+// stream connection via SO_PEERCRED
+func connPeerCredentials(conn *net.UnixConn) (peerCredentials, error) {
+	credentials := peerCredentials{}
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return credentials, err
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return credentials, err
+	}
+	if credErr != nil {
+		return credentials, fmt.Errorf("failed to read SO_PEERCRED: %w", credErr)
+	}
+	credentials.PID, credentials.UID, credentials.GID = ucred.Pid, ucred.Uid, ucred.Gid
+	return credentials, nil
+}
+
+// enablePassCredentials enables SO_PASSCRED on the given UNIX datagram socket, so that
+// the kernel attaches the credentials of the sender to each datagram
+func enablePassCredentials(conn *net.UnixConn) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var optErr error
+	err = rawConn.Control(func(fd uintptr) {
+		optErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		return err
+	}
+	if optErr != nil {
+		return fmt.Errorf("failed to enable SO_PASSCRED: %w", optErr)
+	}
+	return nil
+}
+
+// datagramPeerCredentials returns the sender credentials from the given out-of-band
+// data of a datagram received on a UNIX datagram socket with SO_PASSCRED enabled.
+// If no SCM_CREDENTIALS control message is present, false is returned.
+func datagramPeerCredentials(oob []byte) (peerCredentials, bool) {
+	credentials := peerCredentials{}
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return credentials, false
+	}
+	for i := range messages {
+		ucred, err := syscall.ParseUnixCredentials(&messages[i])
+		if err != nil {
+			continue
+		}
+		credentials.PID, credentials.UID, credentials.GID = ucred.Pid, ucred.Uid, ucred.Gid
+		return credentials, true
+	}
+	return credentials, false
+}
@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+
+package logranger
+
+import (
+	"errors"
+	"net"
+)
+
+// credentialsOOBSize is the size of the out-of-band buffer required to receive the
+// credentials of a datagram. Peer credentials are only supported on Linux.
+var credentialsOOBSize = 0
+
+// errPeerCredentialsUnsupported is returned if peer credentials are requested on a
+// platform other than Linux
+var errPeerCredentialsUnsupported = errors.New("peer credentials are only supported on Linux")
+
+// connPeerCredentials is not supported on this platform
+func connPeerCredentials(*net.UnixConn) (peerCredentials, error) {
+	return peerCredentials{}, errPeerCredentialsUnsupported
+}
+
+// enablePassCredentials is a no-op on this platform
+func enablePassCredentials(*net.UnixConn) error {
+	return nil
+}
+
+// datagramPeerCredentials is not supported on this platform
+func datagramPeerCredentials([]byte) (peerCredentials, bool) {
+	return peerCredentials{}, false
+}
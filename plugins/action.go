@@ -11,9 +11,27 @@ import (
 // Action is an interface that defines the behavior of an action to be performed
 // on a log message.
 //
+// A new instance of the action is created for each rule that uses it. The Config
+// method is called once with the actions configuration of the rule when the ruleset
+// is loaded.
+//
 // The Process method takes a log message, a slice of match groups, and a
 // configuration map, and returns an error if any occurs during processing.
 type Action interface {
 	Config(confmap map[string]any) error
 	Process(logmessage parsesyslog.LogMsg, matchgroup []string) error
 }
+
+// Lifecycle is an optional interface for actions that hold persistent resources,
+// like open files, HTTP clients or database connections.
+//
+// Init is called once after the action has been configured, before it processes
+// the first log message. Flush is called periodically and should write out any
+// buffered data. It may be called concurrently with Process. Close is called after
+// the last log message has been processed, when the ruleset of the action is
+// replaced on reload or the server shuts down. Buffered data is flushed before.
+type Lifecycle interface {
+	Init() error
+	Flush() error
+	Close() error
+}
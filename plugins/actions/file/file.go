@@ -10,6 +10,7 @@ import (
 
 	"github.com/wneessen/go-parsesyslog"
 
+	"github.com/wneessen/logranger/plugins"
 	"github.com/wneessen/logranger/plugins/actions"
 	"github.com/wneessen/logranger/template"
 )
@@ -100,7 +101,9 @@ func (f *File) Process(logMessage parsesyslog.LogMsg, matchGroup []string) error
 	return nil
 }
 
-// init registers the "file" action with the Actions map.
+// init registers the factory of the "file" action with the Actions map.
 func init() {
-	actions.Add("file", &File{})
+	actions.Add("file", func() plugins.Action {
+		return &File{}
+	})
 }
@@ -8,10 +8,13 @@ import (
 	"github.com/wneessen/logranger/plugins"
 )
 
-// Actions is a variable that represents a map of string keys to Action values. The keys are used to identify different actions, and the corresponding values are the functions that define
-var Actions = map[string]plugins.Action{}
+// Factory is a function that returns a new, unconfigured instance of an action.
+type Factory func() plugins.Action
 
-// Add adds an action with the given name to the Actions map. The action function must implement the Action interface.
-func Add(name string, action plugins.Action) {
-	Actions[name] = action
+// Actions is a variable that represents a map of string keys to Factory values. The keys are used to identify different actions, and the corresponding values are the factories that create new instances of them
+var Actions = map[string]Factory{}
+
+// Add adds the factory of an action with the given name to the Actions map. The factory must return a new instance of the action on each call.
+func Add(name string, factory Factory) {
+	Actions[name] = factory
 }
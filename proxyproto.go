@@ -0,0 +1,221 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// proxyV1Prefix is the prefix of a PROXY protocol v1 header
+	proxyV1Prefix = "PROXY "
+	// proxyV1MaxLen is the maximum length of a PROXY protocol v1 header including CRLF
+	proxyV1MaxLen = 107
+	// proxyV2HeaderLen is the length of the fixed part of a PROXY protocol v2 header
+	proxyV2HeaderLen = 16
+)
+
+// proxyV2Signature is the signature that starts a PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyListener wraps a net.Listener and returns connections that read the HAProxy
+// PROXY protocol header (v1 or v2) sent by an upstream load balancer or proxy.
+type proxyListener struct {
+	net.Listener
+	allow []*net.IPNet
+}
+
+// proxyConn wraps a net.Conn with a PROXY protocol header. The header is read on
+// the first call to Read or ReadHeader. After the header has been read, RemoteAddr
+// and LocalAddr return the addresses of the original client connection.
+type proxyConn struct {
+	net.Conn
+	headerErr  error
+	localAddr  net.Addr
+	once       sync.Once
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+	trusted    bool
+}
+
+// newProxyListener wraps the given net.Listener into a proxyListener. Only upstream
+// peers within one of the given allowed networks may send a PROXY protocol header.
+// If no networks are given, the header is accepted from any peer.
+func newProxyListener(listener net.Listener, allow []*net.IPNet) net.Listener {
+	return &proxyListener{Listener: listener, allow: allow}
+}
+
+// Accept satisfies the net.Listener interface for the proxyListener type. It waits
+// for the next connection and returns it wrapped into a proxyConn. The PROXY protocol
+// header is not read in Accept, so that a slow upstream does not block the listener.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	trusted := len(l.allow) == 0 || addrInNetworks(conn.RemoteAddr(), l.allow)
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), trusted: trusted}, nil
+}
+
+// Read satisfies the io.Reader interface for the proxyConn type. It reads the PROXY
+// protocol header before any data is returned.
+func (c *proxyConn) Read(buffer []byte) (int, error) {
+	if err := c.ReadHeader(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(buffer)
+}
+
+// RemoteAddr returns the address of the original client if the PROXY protocol
+// header has been read. Otherwise, the address of the upstream peer is returned.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address of the original client connection if
+// the PROXY protocol header has been read. Otherwise, the local address of the
+// connection is returned.
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ReadHeader reads and parses the PROXY protocol header of the connection. The
+// header is only read once, subsequent calls return the result of the first call.
+// Connections from peers that are not allowed to send a PROXY protocol header are
+// passed through unchanged.
+func (c *proxyConn) ReadHeader() error {
+	c.once.Do(func() {
+		if !c.trusted {
+			return
+		}
+		c.headerErr = c.readHeader()
+	})
+	return c.headerErr
+}
+
+// readHeader detects the version of the PROXY protocol header and parses it
+func (c *proxyConn) readHeader() error {
+	peek, err := c.reader.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+	if string(peek) == proxyV1Prefix {
+		return c.readHeaderV1()
+	}
+	peek, err = c.reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+	if bytes.Equal(peek, proxyV2Signature) {
+		return c.readHeaderV2()
+	}
+	return fmt.Errorf("%w: no PROXY protocol header received from %s", ErrInvalidProxyHeader,
+		c.Conn.RemoteAddr().String())
+}
+
+// readHeaderV1 parses a PROXY protocol v1 header in the form of
+// "PROXY TCP4|TCP6|UNKNOWN SRCADDR DSTADDR SRCPORT DSTPORT\r\n"
+func (c *proxyConn) readHeaderV1() error {
+	line := make([]byte, 0, proxyV1MaxLen)
+	for {
+		char, err := c.reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("failed to read PROXY protocol header: %w", err)
+		}
+		line = append(line, char)
+		if char == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLen {
+			return fmt.Errorf("%w: v1 header exceeds %d bytes", ErrInvalidProxyHeader, proxyV1MaxLen)
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return fmt.Errorf("%w: v1 header not terminated by CRLF", ErrInvalidProxyHeader)
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("%w: malformed v1 header", ErrInvalidProxyHeader)
+	}
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, srcErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstErr := strconv.ParseUint(fields[5], 10, 16)
+	if srcIP == nil || dstIP == nil || srcErr != nil || dstErr != nil {
+		return fmt.Errorf("%w: invalid address in v1 header", ErrInvalidProxyHeader)
+	}
+	c.remoteAddr = &net.TCPAddr{IP: srcIP, Port: int(srcPort)}
+	c.localAddr = &net.TCPAddr{IP: dstIP, Port: int(dstPort)}
+	return nil
+}
+
+// readHeaderV2 parses a binary PROXY protocol v2 header. Only the source and destination
+// addresses of TCP over IPv4 and IPv6 are evaluated, TLVs are skipped. For the LOCAL
+// command (e.g. health checks of the proxy), the addresses of the connection are kept.
+func (c *proxyConn) readHeaderV2() error {
+	header := make([]byte, proxyV2HeaderLen)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+	version, command := header[12]>>4, header[12]&0x0F
+	if version != 2 {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidProxyHeader, version)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return fmt.Errorf("failed to read PROXY protocol header: %w", err)
+	}
+
+	switch command {
+	case 0x0:
+		return nil
+	case 0x1:
+	default:
+		return fmt.Errorf("%w: unsupported command %d", ErrInvalidProxyHeader, command)
+	}
+
+	family, protocol := header[13]>>4, header[13]&0x0F
+	if protocol != 0x1 {
+		return nil
+	}
+	var ipLen int
+	switch family {
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	default:
+		return nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return fmt.Errorf("%w: v2 address block too short", ErrInvalidProxyHeader)
+	}
+	c.remoteAddr = &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	c.localAddr = &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+	return nil
+}
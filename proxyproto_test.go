@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestProxyConn_ReadHeader(t *testing.T) {
+	ipv4Addrs := append(net.ParseIP("192.0.2.1").To4(), net.ParseIP("198.51.100.2").To4()...)
+	ipv4Addrs = binary.BigEndian.AppendUint16(ipv4Addrs, 51234)
+	ipv4Addrs = binary.BigEndian.AppendUint16(ipv4Addrs, 514)
+	ipv6Addrs := append(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")...)
+	ipv6Addrs = binary.BigEndian.AppendUint16(ipv6Addrs, 51234)
+	ipv6Addrs = binary.BigEndian.AppendUint16(ipv6Addrs, 514)
+	tlv := []byte{0x04, 0x00, 0x01, 0xff}
+
+	tests := []struct {
+		name       string
+		header     []byte
+		trusted    bool
+		wantRemote string
+		wantLocal  string
+		wantErr    error
+	}{
+		{
+			"v1 TCP4", []byte("PROXY TCP4 192.0.2.1 198.51.100.2 51234 514\r\n"), true,
+			"192.0.2.1:51234", "198.51.100.2:514", nil,
+		},
+		{
+			"v1 TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 51234 514\r\n"), true,
+			"[2001:db8::1]:51234", "[2001:db8::2]:514", nil,
+		},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), true, "pipe", "pipe", nil},
+		{
+			"v1 without CRLF", []byte("PROXY TCP4 192.0.2.1 198.51.100.2 51234 514\n"), true,
+			"", "", ErrInvalidProxyHeader,
+		},
+		{
+			"v1 with missing fields", []byte("PROXY TCP4 192.0.2.1 198.51.100.2 51234\r\n"), true,
+			"", "", ErrInvalidProxyHeader,
+		},
+		{
+			"v1 with unknown protocol", []byte("PROXY UDP4 192.0.2.1 198.51.100.2 51234 514\r\n"), true,
+			"", "", ErrInvalidProxyHeader,
+		},
+		{
+			"v1 with invalid address", []byte("PROXY TCP4 192.0.2.300 198.51.100.2 51234 514\r\n"), true,
+			"", "", ErrInvalidProxyHeader,
+		},
+		{
+			"v1 with invalid port", []byte("PROXY TCP4 192.0.2.1 198.51.100.2 65536 514\r\n"), true,
+			"", "", ErrInvalidProxyHeader,
+		},
+		{
+			"v1 exceeding maximum length", []byte("PROXY " + strings.Repeat("x", proxyV1MaxLen) + "\r\n"),
+			true, "", "", ErrInvalidProxyHeader,
+		},
+		{"v1 truncated", []byte("PROXY TCP4 192.0.2.1"), true, "", "", io.EOF},
+		{
+			"v2 TCP over IPv4", proxyV2Header(0x21, 0x11, ipv4Addrs), true,
+			"192.0.2.1:51234", "198.51.100.2:514", nil,
+		},
+		{
+			"v2 TCP over IPv6", proxyV2Header(0x21, 0x21, ipv6Addrs), true,
+			"[2001:db8::1]:51234", "[2001:db8::2]:514", nil,
+		},
+		{
+			"v2 with TLV", proxyV2Header(0x21, 0x11, append(ipv4Addrs, tlv...)), true,
+			"192.0.2.1:51234", "198.51.100.2:514", nil,
+		},
+		{"v2 LOCAL command", proxyV2Header(0x20, 0x00, nil), true, "pipe", "pipe", nil},
+		{"v2 UDP over IPv4", proxyV2Header(0x21, 0x12, ipv4Addrs), true, "pipe", "pipe", nil},
+		{"v2 UNIX stream", proxyV2Header(0x21, 0x31, make([]byte, 216)), true, "pipe", "pipe", nil},
+		{
+			"v2 with unsupported version", proxyV2Header(0x11, 0x11, ipv4Addrs), true,
+			"", "", ErrInvalidProxyHeader,
+		},
+		{
+			"v2 with unsupported command", proxyV2Header(0x22, 0x11, ipv4Addrs), true,
+			"", "", ErrInvalidProxyHeader,
+		},
+		{
+			"v2 with short address block", proxyV2Header(0x21, 0x11, ipv4Addrs[:8]), true,
+			"", "", ErrInvalidProxyHeader,
+		},
+		{
+			"v2 truncated", proxyV2Header(0x21, 0x11, ipv4Addrs)[:proxyV2HeaderLen+4], true,
+			"", "", io.ErrUnexpectedEOF,
+		},
+		{"no header", []byte("<13>message without header\n"), true, "", "", ErrInvalidProxyHeader},
+		{
+			"untrusted peer", []byte("PROXY TCP4 192.0.2.1 198.51.100.2 51234 514\r\n"), false,
+			"pipe", "pipe", nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const data = "<13>message\n"
+			conn, peer := net.Pipe()
+			defer func() {
+				_ = conn.Close()
+				_ = peer.Close()
+			}()
+			input := append(append([]byte{}, tt.header...), data...)
+			if tt.wantErr != nil {
+				input = tt.header
+			}
+			proxy := &proxyConn{Conn: conn, reader: bufio.NewReader(bytes.NewReader(input)), trusted: tt.trusted}
+
+			err := proxy.ReadHeader()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadHeader returned error %v, want %v", err, tt.wantErr)
+			}
+			if !errors.Is(proxy.ReadHeader(), tt.wantErr) {
+				t.Errorf("second call to ReadHeader returned a different result")
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got := proxy.RemoteAddr().String(); got != tt.wantRemote {
+				t.Errorf("RemoteAddr = %s, want %s", got, tt.wantRemote)
+			}
+			if got := proxy.LocalAddr().String(); got != tt.wantLocal {
+				t.Errorf("LocalAddr = %s, want %s", got, tt.wantLocal)
+			}
+			remaining, err := io.ReadAll(proxy)
+			if err != nil {
+				t.Fatalf("failed to read data after header: %s", err)
+			}
+			want := data
+			if !tt.trusted {
+				want = string(input)
+			}
+			if string(remaining) != want {
+				t.Errorf("data after header = %q, want %q", remaining, want)
+			}
+		})
+	}
+}
+
+// proxyV2Header returns a PROXY protocol v2 header with the given version and command
+// byte, address family and protocol byte and payload
+func proxyV2Header(versionCommand, familyProtocol byte, payload []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, versionCommand, familyProtocol)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
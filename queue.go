@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wneessen/go-parsesyslog"
+)
+
+// QueuePolicy is an enumeration wrapper for the different ways of handling messages
+// when the processing queue of the Server is full
+type QueuePolicy uint
+
+const (
+	// QueueBlock is a constant of type QueuePolicy that represents blocking the input
+	// until the queue has room for the message. This pushes back on stream senders.
+	QueueBlock QueuePolicy = iota
+	// QueueDrop is a constant of type QueuePolicy that represents dropping messages
+	// that do not fit into the queue.
+	QueueDrop
+)
+
+// queueLogInterval is the minimum interval between two log entries about messages
+// that have been dropped because the queue was full
+const queueLogInterval = 10 * time.Second
+
+// queuedMessage is a log message in the processing queue together with the reference
+// to its record in the spool, if the spool is enabled
+type queuedMessage struct {
+	logMessage parsesyslog.LogMsg
+	spoolRef   *spoolRef
+}
+
+// startWorkers starts the configured number of workers that take log messages from
+// the processing queue and match them against the ruleset. Once a message has been
+// processed, it is acknowledged in the spool.
+func (s *Server) startWorkers() {
+	for i := 0; i < s.config().Queue.Workers; i++ {
+		go func() {
+			for message := range s.queue {
+				s.ackMessage(message, s.processMessage(message.logMessage))
+				s.wg.Done()
+			}
+		}()
+	}
+}
+
+// ackMessage acknowledges the spool record of the given message. If success is false,
+// the record is kept in the spool for replay on the next start.
+func (s *Server) ackMessage(message queuedMessage, success bool) {
+	if message.spoolRef == nil {
+		return
+	}
+	if err := s.spool.Ack(message.spoolRef, success); err != nil {
+		s.log.Error("failed to acknowledge message in spool", LogErrKey, err)
+	}
+}
+
+// replaySpool puts the messages that remained in the spool from a previous run into
+// the processing queue. Replayed messages are never dropped, regardless of the
+// configured queue policy.
+func (s *Server) replaySpool() error {
+	return s.spool.Replay(s.log, func(logMessage parsesyslog.LogMsg) error {
+		return s.enqueueMessage(logMessage, QueueBlock)
+	})
+}
+
+// UnmarshalString satisfies the fig.StringUnmarshaler interface for the QueuePolicy type
+func (p *QueuePolicy) UnmarshalString(value string) error {
+	switch strings.ToLower(value) {
+	case "block":
+		*p = QueueBlock
+	case "drop":
+		*p = QueueDrop
+	default:
+		return fmt.Errorf("unknown queue policy: %s", value)
+	}
+	return nil
+}
+
+// String satisfies the fmt.Stringer interface for the QueuePolicy type
+func (p QueuePolicy) String() string {
+	switch p {
+	case QueueBlock:
+		return "block"
+	case QueueDrop:
+		return "drop"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,227 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+)
+
+// serverRuntime holds the parts of the Server that are replaced as a whole when the
+// configuration is reloaded: the configuration, the ruleset and the listeners. A
+// serverRuntime is never modified once it is active. Workers acquire the runtime
+// while they use its actions, so that the actions of a replaced runtime are only
+// closed once they are not used anymore.
+type serverRuntime struct {
+	active    sync.RWMutex
+	conf      *Config
+	listeners []*listenerInstance
+	retired   bool
+	ruleset   *Ruleset
+}
+
+// newServerRuntime loads the ruleset and sets up the listeners for the given Config.
+// The listeners are not opened yet.
+func newServerRuntime(config *Config) (*serverRuntime, error) {
+	ruleset, err := NewRuleset(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ruleset: %w", err)
+	}
+	runtime := &serverRuntime{conf: config, ruleset: ruleset}
+	for i := range config.Listeners {
+		instance, err := newListenerInstance(&config.Listeners[i])
+		if err != nil {
+			return nil, err
+		}
+		runtime.listeners = append(runtime.listeners, instance)
+	}
+	return runtime, nil
+}
+
+// acquireRuntime returns the currently active runtime of the Server and makes sure
+// that its actions are not closed until release is called
+func (s *Server) acquireRuntime() *serverRuntime {
+	for {
+		current := s.runtime.Load()
+		current.active.RLock()
+		if !current.retired {
+			return current
+		}
+		current.active.RUnlock()
+	}
+}
+
+// release releases a runtime that has been acquired with acquireRuntime
+func (r *serverRuntime) release() {
+	r.active.RUnlock()
+}
+
+// retireRuntime waits until the given runtime, which must have been replaced
+// already, is not used anymore and closes its actions
+func (s *Server) retireRuntime(runtime *serverRuntime) {
+	runtime.active.Lock()
+	runtime.retired = true
+	runtime.active.Unlock()
+	s.closeActions(runtime.ruleset)
+}
+
+// config returns the currently active Config of the Server
+func (s *Server) config() *Config {
+	return s.runtime.Load().conf
+}
+
+// ReloadConfig reloads the configuration of the Server with the specified
+// path and filename.
+// It builds a new runtime from the new Config, consisting of the Config, the
+// Ruleset and the listeners, and swaps it with the active one once it is complete.
+// The actions of the new Ruleset are initialized before the swap, the actions of
+// the previous Ruleset are closed once they have finished processing.
+// Listeners whose settings are unchanged keep running and reload the certificates
+// of TLS listeners. Changed and new listeners are opened first, a changed listener
+// that keeps its address takes over the socket of the previous listener. Listeners
+// that have been changed or removed are closed once the new runtime is active. If
+// any of the listeners fails to open, the previous listeners keep running and the
+// previous runtime stays active.
+// The queue, spool and file input settings are only applied on restart.
+// If an error occurs while reloading the configuration, an error is returned.
+func (s *Server) ReloadConfig(path, file string) error {
+	config, err := NewConfig(path, file)
+	if err != nil {
+		return fmt.Errorf("failed to reload config: %w", err)
+	}
+	if err = s.reload(config); err != nil {
+		return fmt.Errorf("failed to reload config: %w", err)
+	}
+	return nil
+}
+
+// reload builds a new runtime from the given Config and makes it active, as
+// described for ReloadConfig
+func (s *Server) reload(config *Config) error {
+	next, err := newServerRuntime(config)
+	if err != nil {
+		return err
+	}
+	if err = s.initActions(next.ruleset); err != nil {
+		return err
+	}
+
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+	if s.shuttingDown() {
+		s.closeActions(next.ruleset)
+		return ErrServerShutdown
+	}
+	current := s.runtime.Load()
+
+	unchanged := make(map[*listenerInstance]struct{})
+	var started []*listenerInstance
+	for i, instance := range next.listeners {
+		previous := findListener(current.listeners, instance.conf.Name)
+		if previous != nil && reflect.DeepEqual(previous.conf, instance.conf) {
+			next.listeners[i] = previous
+			unchanged[previous] = struct{}{}
+			continue
+		}
+		started = append(started, instance)
+	}
+	var stopped []*listenerInstance
+	for _, instance := range current.listeners {
+		if _, ok := unchanged[instance]; !ok {
+			stopped = append(stopped, instance)
+		}
+	}
+
+	takenOver, err := s.openListeners(next.listeners, started, stopped)
+	if err != nil {
+		s.closeActions(next.ruleset)
+		return err
+	}
+
+	s.runtime.Store(next)
+	for _, instance := range started {
+		s.serveListener(instance)
+	}
+	for _, instance := range stopped {
+		s.log.Info("closing changed or removed listener", slog.String("listener", instance.conf.Name))
+		if _, ok := takenOver[instance]; ok {
+			instance.keepSocketFile()
+		}
+		s.stopListener(instance)
+	}
+	s.retireRuntime(current)
+
+	for instance := range unchanged {
+		certs := instance.certReloader()
+		if certs == nil {
+			continue
+		}
+		if err = certs.Reload(); err != nil {
+			s.log.Error("failed to reload TLS certificate", LogErrKey, err,
+				slog.String("listener", instance.conf.Name))
+		}
+	}
+
+	return nil
+}
+
+// openListeners opens the started listeners of a reload while the stopped listeners
+// keep running. A started listener that is bound to the same address as one of the
+// stopped listeners takes over its socket, since the address cannot be bound twice.
+// It returns the stopped listeners whose sockets have been taken over. If any of the
+// started listeners fails to open, the listeners that have been opened already are
+// closed again and an error is returned. The stopped listeners are not touched in
+// any case.
+func (s *Server) openListeners(listeners, started, stopped []*listenerInstance) (map[*listenerInstance]struct{}, error) {
+	addresses := make(map[string]string, len(listeners))
+	for _, instance := range listeners {
+		address := socketAddress(instance.conf)
+		if name, ok := addresses[address]; ok {
+			return nil, fmt.Errorf("listeners %q and %q are bound to the same address", name,
+				instance.conf.Name)
+		}
+		addresses[address] = instance.conf.Name
+	}
+	previous := make(map[string]*listenerInstance, len(stopped))
+	for _, instance := range stopped {
+		previous[socketAddress(instance.conf)] = instance
+	}
+
+	takenOver := make(map[*listenerInstance]struct{})
+	for i, instance := range started {
+		var err error
+		stoppedInstance, ok := previous[socketAddress(instance.conf)]
+		switch {
+		case !ok:
+			err = instance.open()
+		case stoppedInstance.conf.Type.IsPacketListener() != instance.conf.Type.IsPacketListener():
+			err = fmt.Errorf("address is still in use by listener %q", stoppedInstance.conf.Name)
+		default:
+			if err = instance.takeOver(stoppedInstance); err == nil {
+				takenOver[stoppedInstance] = struct{}{}
+			}
+		}
+		if err != nil {
+			for _, opened := range started[:i] {
+				opened.close()
+			}
+			return nil, fmt.Errorf("failed to open listener %q: %w", instance.conf.Name, err)
+		}
+	}
+	return takenOver, nil
+}
+
+// findListener returns the listener with the given name from the given listeners or
+// nil if there is no such listener
+func findListener(listeners []*listenerInstance, name string) *listenerInstance {
+	for _, instance := range listeners {
+		if instance.conf.Name == name {
+			return instance
+		}
+	}
+	return nil
+}
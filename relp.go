@@ -0,0 +1,289 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// RELPCommandOpen is the RELP command that opens a session
+	RELPCommandOpen = "open"
+	// RELPCommandSyslog is the RELP command that transmits a syslog message
+	RELPCommandSyslog = "syslog"
+	// RELPCommandClose is the RELP command that closes a session
+	RELPCommandClose = "close"
+	// RELPCommandResponse is the RELP command used for responses to the client
+	RELPCommandResponse = "rsp"
+	// RELPCommandServerClose is the RELP command that informs the client that the
+	// server is closing the session
+	RELPCommandServerClose = "serverclose"
+)
+
+const (
+	// relpMaxTxnrDigits is the maximum amount of digits of a RELP transaction number
+	relpMaxTxnrDigits = 9
+	// relpMaxCommandLen is the maximum length of a RELP command
+	relpMaxCommandLen = 32
+	// relpMaxDataLen is the upper bound for the maximum length of the data of a RELP
+	// frame. This matches the default maximum message size of librelp.
+	relpMaxDataLen = 128 * 1024
+	// relpVersion is the RELP protocol version supported by logranger
+	relpVersion = "0"
+	// relpSoftware is the software name announced to RELP clients
+	relpSoftware = "logranger"
+)
+
+// RELPFrame represents a single frame of the Reliable Event Logging Protocol
+// in the form of "TXNR SP COMMAND SP DATALEN [SP DATA] TRAILER"
+type RELPFrame struct {
+	Txnr    uint64
+	Command string
+	Data    []byte
+}
+
+// ReadRELPFrame reads a single RELP frame from the given bufio.Reader. If the data
+// of the frame exceeds maxDataLen bytes, ErrMessageTooLarge is returned.
+func ReadRELPFrame(reader *bufio.Reader, maxDataLen int) (RELPFrame, error) {
+	frame := RELPFrame{}
+	if err := skipEmptyLines(reader); err != nil {
+		return frame, err
+	}
+
+	txnrField, delim, err := readRELPToken(reader, relpMaxTxnrDigits)
+	if err != nil {
+		return frame, err
+	}
+	if delim != ' ' {
+		return frame, fmt.Errorf("%w: missing command", ErrInvalidFrame)
+	}
+	frame.Txnr, err = strconv.ParseUint(txnrField, 10, 64)
+	if err != nil {
+		return frame, fmt.Errorf("%w: invalid transaction number %q", ErrInvalidFrame, txnrField)
+	}
+
+	frame.Command, delim, err = readRELPToken(reader, relpMaxCommandLen)
+	if err != nil {
+		return frame, err
+	}
+	if delim != ' ' {
+		return frame, fmt.Errorf("%w: missing data length", ErrInvalidFrame)
+	}
+
+	dataLenField, delim, err := readRELPToken(reader, len(strconv.Itoa(relpMaxDataLen)))
+	if err != nil {
+		return frame, err
+	}
+	dataLen, err := strconv.Atoi(dataLenField)
+	if err != nil || dataLen < 0 || dataLen > relpMaxDataLen {
+		return frame, fmt.Errorf("%w: invalid data length %q", ErrInvalidFrame, dataLenField)
+	}
+	if dataLen > maxDataLen {
+		return frame, fmt.Errorf("%w: RELP frame data of %d bytes", ErrMessageTooLarge, dataLen)
+	}
+	if dataLen == 0 {
+		if delim != '\n' {
+			return frame, fmt.Errorf("%w: missing trailer", ErrInvalidFrame)
+		}
+		return frame, nil
+	}
+	if delim != ' ' {
+		return frame, fmt.Errorf("%w: missing data", ErrInvalidFrame)
+	}
+
+	frame.Data = make([]byte, dataLen)
+	if _, err = io.ReadFull(reader, frame.Data); err != nil {
+		return frame, err
+	}
+	trailer, err := reader.ReadByte()
+	if err != nil {
+		return frame, err
+	}
+	if trailer != '\n' {
+		return frame, fmt.Errorf("%w: missing trailer", ErrInvalidFrame)
+	}
+	return frame, nil
+}
+
+// WriteRELPFrame writes a single RELP frame to the given bufio.Writer and flushes it
+func WriteRELPFrame(writer *bufio.Writer, frame RELPFrame) error {
+	if _, err := fmt.Fprintf(writer, "%d %s %d", frame.Txnr, frame.Command, len(frame.Data)); err != nil {
+		return err
+	}
+	if len(frame.Data) > 0 {
+		if err := writer.WriteByte(' '); err != nil {
+			return err
+		}
+		if _, err := writer.Write(frame.Data); err != nil {
+			return err
+		}
+	}
+	if err := writer.WriteByte('\n'); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// readRELPToken reads a token of the RELP header from the given bufio.Reader until a
+// SP or LF is found and returns the token and the delimiter. If the token exceeds
+// the given maximum length, an error is returned.
+func readRELPToken(reader *bufio.Reader, maxLen int) (string, byte, error) {
+	token := make([]byte, 0, maxLen)
+	for {
+		char, err := reader.ReadByte()
+		if err != nil {
+			return "", 0, err
+		}
+		if char == ' ' || char == '\n' {
+			if len(token) == 0 {
+				return "", 0, fmt.Errorf("%w: empty header field", ErrInvalidFrame)
+			}
+			return string(token), char, nil
+		}
+		if len(token) >= maxLen {
+			return "", 0, fmt.Errorf("%w: header field exceeds %d characters", ErrInvalidFrame, maxLen)
+		}
+		token = append(token, char)
+	}
+}
+
+// handleRELPConnection handles a single connection of a RELP listener. It implements
+// the server side of the open/syslog/rsp/close command exchange of the Reliable Event
+// Logging Protocol. A syslog message is only acknowledged with a positive response
+// after it has been successfully parsed and accepted for processing. Otherwise, a
+// negative response is sent, so that the client can retransmit the message. When the
+// Server is shut down, the client is informed with a serverclose command.
+func (s *Server) handleRELPConnection(connection *Connection) {
+	defer func() {
+		if err := connection.conn.Close(); err != nil {
+			s.log.Error("failed to close connection", LogErrKey, err)
+		}
+	}()
+
+	instance := connection.listener
+	if err := connection.conn.SetDeadline(time.Now().Add(instance.conf.ReadTimeout)); err != nil {
+		s.log.Error("failed to set processing deadline", LogErrKey, err,
+			slog.Duration("timeout", instance.conf.ReadTimeout))
+		return
+	}
+	if err := connection.Handshake(); err != nil {
+		s.log.Error("connection handshake failed", LogErrKey, err,
+			slog.String("remote_addr", connection.conn.RemoteAddr().String()))
+		return
+	}
+
+	maxDataLen := min(int(instance.conf.MaxMessageSize), relpMaxDataLen)
+	sessionOpen := false
+	for {
+		if s.shuttingDown() && connection.rb.Buffered() == 0 {
+			_ = WriteRELPFrame(connection.wb, RELPFrame{Command: RELPCommandServerClose})
+			return
+		}
+		err := connection.awaitData()
+		var frame RELPFrame
+		if err == nil {
+			frame, err = ReadRELPFrame(connection.rb, maxDataLen)
+		}
+		if err != nil {
+			var netErr *net.OpError
+			switch {
+			case s.shuttingDown():
+				// Shutdown interrupts pending reads by setting an expired read deadline,
+				// the client is still informed that the session is closed by the server.
+				_ = WriteRELPFrame(connection.wb, RELPFrame{Command: RELPCommandServerClose})
+			case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+				if s.config().Log.Extended {
+					s.log.Error("RELP session terminated", LogErrKey, err)
+				}
+			default:
+				s.log.Error("failed to read RELP frame", LogErrKey, err,
+					slog.String("listener", instance.conf.Name))
+				_ = WriteRELPFrame(connection.wb, RELPFrame{Command: RELPCommandServerClose})
+			}
+			return
+		}
+
+		if !sessionOpen && frame.Command != RELPCommandOpen {
+			s.log.Error("RELP command received before session was opened",
+				slog.String("command", frame.Command), slog.String("listener", instance.conf.Name))
+			_ = s.respondRELP(connection, frame.Txnr, "500 session not opened")
+			return
+		}
+
+		switch frame.Command {
+		case RELPCommandOpen:
+			offers := parseRELPOffers(frame.Data)
+			if !strings.Contains(","+offers["commands"]+",", ","+RELPCommandSyslog+",") {
+				_ = s.respondRELP(connection, frame.Txnr, "500 required command syslog not offered")
+				return
+			}
+			sessionOpen = true
+			response := fmt.Sprintf("200 OK\nrelp_version=%s\nrelp_software=%s\ncommands=%s",
+				relpVersion, relpSoftware, RELPCommandSyslog)
+			err = s.respondRELP(connection, frame.Txnr, response)
+		case RELPCommandSyslog:
+			err = s.respondRELP(connection, frame.Txnr, s.acceptRELPMessage(connection, frame.Data))
+		case RELPCommandClose:
+			_ = WriteRELPFrame(connection.wb, RELPFrame{Txnr: frame.Txnr, Command: RELPCommandResponse})
+			return
+		default:
+			err = s.respondRELP(connection, frame.Txnr, "500 unsupported command")
+		}
+		if err != nil {
+			s.log.Error("failed to send RELP response", LogErrKey, err,
+				slog.String("listener", instance.conf.Name))
+			return
+		}
+	}
+}
+
+// acceptRELPMessage parses the given syslog message received via RELP and hands it
+// over for processing. It returns the RELP response that is sent to the client.
+func (s *Server) acceptRELPMessage(connection *Connection, data []byte) string {
+	instance := connection.listener
+	logMessage, err := instance.parser.Parse(data, connection.conn.RemoteAddr())
+	if err != nil {
+		s.log.Error("failed to parse message", LogErrKey, err,
+			slog.String("listener", instance.conf.Name),
+			slog.String("parser_type", instance.conf.Parser))
+		return "500 failed to parse message"
+	}
+	if err = s.dispatchMessage(logMessage, connection.meta); err != nil {
+		return "500 message not accepted"
+	}
+	return "200 OK"
+}
+
+// respondRELP sends a RELP response with the given transaction number and response
+// data to the client
+func (s *Server) respondRELP(connection *Connection, txnr uint64, response string) error {
+	return WriteRELPFrame(connection.wb, RELPFrame{
+		Txnr:    txnr,
+		Command: RELPCommandResponse,
+		Data:    []byte(response),
+	})
+}
+
+// parseRELPOffers parses the offers of a RELP open command in the form of
+// "name=value" lines and returns them as a map
+func parseRELPOffers(data []byte) map[string]string {
+	offers := make(map[string]string)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		name, value, _ := strings.Cut(strings.TrimSpace(string(line)), "=")
+		if name != "" {
+			offers[name] = value
+		}
+	}
+	return offers
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadRELPFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    RELPFrame
+		wantErr error
+	}{
+		{
+			"open command", "1 open 30 relp_version=0\ncommands=syslog\n",
+			RELPFrame{Txnr: 1, Command: RELPCommandOpen, Data: []byte("relp_version=0\ncommands=syslog")}, nil,
+		},
+		{
+			"syslog command", "2 syslog 11 <13>message\n",
+			RELPFrame{Txnr: 2, Command: RELPCommandSyslog, Data: []byte("<13>message")}, nil,
+		},
+		{
+			"close command without data", "3 close 0\n",
+			RELPFrame{Txnr: 3, Command: RELPCommandClose}, nil,
+		},
+		{
+			"leading empty lines", "\n\r\n4 close 0\n",
+			RELPFrame{Txnr: 4, Command: RELPCommandClose}, nil,
+		},
+		{"empty stream", "", RELPFrame{}, io.EOF},
+		{"invalid transaction number", "abc syslog 0\n", RELPFrame{}, ErrInvalidFrame},
+		{"transaction number too long", "1234567890 syslog 0\n", RELPFrame{}, ErrInvalidFrame},
+		{"missing command", "1\n", RELPFrame{}, ErrInvalidFrame},
+		{"empty command", "1  0\n", RELPFrame{}, ErrInvalidFrame},
+		{"missing data length", "1 syslog\n", RELPFrame{}, ErrInvalidFrame},
+		{"invalid data length", "1 syslog x1 <13>m\n", RELPFrame{}, ErrInvalidFrame},
+		{"data length too large", "1 syslog 999999 <13>m\n", RELPFrame{}, ErrInvalidFrame},
+		{"missing data", "1 syslog 4\n", RELPFrame{}, ErrInvalidFrame},
+		{"missing trailer without data", "1 close 0 \n", RELPFrame{}, ErrInvalidFrame},
+		{"missing trailer", "1 syslog 4 <13>mx", RELPFrame{}, ErrInvalidFrame},
+		{"short data", "1 syslog 10 <13>m", RELPFrame{}, io.ErrUnexpectedEOF},
+		{"truncated header", "1 sys", RELPFrame{}, io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := ReadRELPFrame(bufio.NewReader(strings.NewReader(tt.input)), relpMaxDataLen)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadRELPFrame returned error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if frame.Txnr != tt.want.Txnr || frame.Command != tt.want.Command ||
+				!bytes.Equal(frame.Data, tt.want.Data) {
+				t.Errorf("ReadRELPFrame = {%d %s %q}, want {%d %s %q}", frame.Txnr, frame.Command,
+					frame.Data, tt.want.Txnr, tt.want.Command, tt.want.Data)
+			}
+		})
+	}
+}
+
+func TestReadRELPFrame_maxDataLen(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		maxDataLen int
+		wantErr    error
+	}{
+		{"data within limit", "1 syslog 11 <13>message\n", 11, nil},
+		{"data exceeding limit", "1 syslog 11 <13>message\n", 10, ErrMessageTooLarge},
+		{"data exceeding upper bound", "1 syslog 131073 <13>m\n", relpMaxDataLen * 2, ErrInvalidFrame},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadRELPFrame(bufio.NewReader(strings.NewReader(tt.input)), tt.maxDataLen)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadRELPFrame returned error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteRELPFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame RELPFrame
+		want  string
+	}{
+		{
+			"response with data", RELPFrame{Txnr: 2, Command: RELPCommandResponse, Data: []byte("200 OK")},
+			"2 rsp 6 200 OK\n",
+		},
+		{"serverclose without data", RELPFrame{Command: RELPCommandServerClose}, "0 serverclose 0\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := bytes.NewBuffer(nil)
+			if err := WriteRELPFrame(bufio.NewWriter(buffer), tt.frame); err != nil {
+				t.Fatalf("WriteRELPFrame failed: %s", err)
+			}
+			if buffer.String() != tt.want {
+				t.Fatalf("WriteRELPFrame wrote %q, want %q", buffer.String(), tt.want)
+			}
+			frame, err := ReadRELPFrame(bufio.NewReader(buffer), relpMaxDataLen)
+			if err != nil {
+				t.Fatalf("failed to read written frame: %s", err)
+			}
+			if frame.Txnr != tt.frame.Txnr || frame.Command != tt.frame.Command ||
+				!bytes.Equal(frame.Data, tt.frame.Data) {
+				t.Errorf("read frame does not match written frame: got {%d %s %q}, want {%d %s %q}",
+					frame.Txnr, frame.Command, frame.Data, tt.frame.Txnr, tt.frame.Command, tt.frame.Data)
+			}
+		})
+	}
+}
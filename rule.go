@@ -9,9 +9,15 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/kkyr/fig"
+	"github.com/wneessen/go-parsesyslog"
+
+	"github.com/wneessen/logranger/metadata"
+	"github.com/wneessen/logranger/plugins"
+	"github.com/wneessen/logranger/plugins/actions"
 )
 
 // Ruleset represents a collection of rules.
@@ -21,10 +27,19 @@ type Ruleset struct {
 
 // Rule represents a rule with its properties.
 type Rule struct {
-	ID        string         `fig:"id" validate:"required"`
-	Regexp    *regexp.Regexp `fig:"regexp" validate:"required"`
-	HostMatch *regexp.Regexp `fig:"host_match"`
-	Actions   map[string]any `fig:"actions"`
+	ID        string                    `fig:"id" validate:"required"`
+	Regexp    *regexp.Regexp            `fig:"regexp" validate:"required"`
+	HostMatch *regexp.Regexp            `fig:"host_match"`
+	MetaMatch map[string]*regexp.Regexp `fig:"meta_match"`
+	Actions   map[string]any            `fig:"actions"`
+
+	actions []ruleAction
+}
+
+// ruleAction is a configured instance of an action for a single rule
+type ruleAction struct {
+	name   string
+	action plugins.Action
 }
 
 // NewRuleset initializes a new Ruleset based on the provided Config.
@@ -55,5 +70,51 @@ func NewRuleset(config *Config) (*Ruleset, error) {
 		rules = append(rules, rule.ID)
 	}
 
+	for i := range ruleset.Rule {
+		if err = ruleset.Rule[i].configureActions(); err != nil {
+			return nil, err
+		}
+	}
+
 	return ruleset, nil
 }
+
+// configureActions creates and configures a new instance of each action that is
+// configured for the rule. It returns an error if an action is unknown or its
+// configuration is invalid.
+func (r *Rule) configureActions() error {
+	names := make([]string, 0, len(r.Actions))
+	for name := range r.Actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		factory, ok := actions.Actions[name]
+		if !ok {
+			return fmt.Errorf("unknown action %q in rule %q", name, r.ID)
+		}
+		action := factory()
+		if err := action.Config(r.Actions); err != nil {
+			return fmt.Errorf("failed to configure action %q for rule %q: %w", name, r.ID, err)
+		}
+		r.actions = append(r.actions, ruleAction{name: name, action: action})
+	}
+	return nil
+}
+
+// MatchMetadata checks if the metadata of the given log message matches all the
+// regular expressions in the MetaMatch map of the Rule. A metadata key that is not
+// present on the log message is matched against an empty string.
+// It returns true if all regular expressions match or if no MetaMatch is configured.
+func (r Rule) MatchMetadata(logMessage parsesyslog.LogMsg) bool {
+	for key, matcher := range r.MetaMatch {
+		if matcher == nil {
+			continue
+		}
+		value, _ := metadata.Get(logMessage, key)
+		if !matcher.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
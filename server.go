@@ -5,6 +5,7 @@
 package logranger
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,12 +14,14 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/wneessen/go-parsesyslog"
 	_ "github.com/wneessen/go-parsesyslog/rfc3164"
 	_ "github.com/wneessen/go-parsesyslog/rfc5424"
 
+	"github.com/wneessen/logranger/metadata"
 	"github.com/wneessen/logranger/plugins/actions"
 	_ "github.com/wneessen/logranger/plugins/actions/all"
 )
@@ -28,70 +31,254 @@ const (
 	LogErrKey = "error"
 )
 
+const (
+	// minAcceptDelay is the initial delay before accepting new connections again after
+	// an accept error
+	minAcceptDelay = 5 * time.Millisecond
+	// maxAcceptDelay is the maximum delay before accepting new connections again after
+	// repeated accept errors
+	maxAcceptDelay = time.Second
+)
+
 // Server is the main server struct
 type Server struct {
-	// conf is a pointer to the config.Config
-	conf *Config
-	// listener is a listener that satisfies the net.Listener interface
-	listener net.Listener
+	// connections holds all open connections of the stream listeners
+	connections map[*Connection]struct{}
+	// connMutex protects connections
+	connMutex sync.Mutex
+	// done is closed when the Server is shut down
+	done chan struct{}
+	// dropped counts the messages that have been dropped because the queue was full
+	dropped atomic.Uint64
+	// dropLogs rate-limits the log entries about dropped messages
+	dropLogs logLimiter
+	// fileTailers holds all configured file-tailing inputs of the Server
+	fileTailers []*fileTailer
 	// log is a pointer to the slog.Logger
 	log *slog.Logger
-	// parser is a parsesyslog.Parser
-	parser parsesyslog.Parser
-	// ruleset is a pointer to the ruleset
-	ruleset *Ruleset
+	// pidFile is the path of the PID file that has been created by Run
+	pidFile string
+	// queue is the processing queue between the inputs and the workers
+	queue chan queuedMessage
+	// reloadMutex serializes reloads of the runtime with Run and Shutdown
+	reloadMutex sync.Mutex
+	// runtime holds the configuration, ruleset and listeners that are currently active
+	runtime atomic.Pointer[serverRuntime]
+	// shutdownOnce makes sure that done is only closed once
+	shutdownOnce sync.Once
+	// spool is the on-disk spool of accepted messages, if enabled
+	spool *spool
 	// wg is a sync.WaitGroup
 	wg sync.WaitGroup
 }
 
-// New creates a new instance of Server based on the provided Config
-func New(config *Config) (*Server, error) {
-	server := &Server{
-		conf: config,
+// New creates a new instance of Server based on the provided Config. If an error
+// occurs, the actions that have already been initialized are closed again.
+func New(config *Config) (server *Server, err error) {
+	server = &Server{
+		connections: make(map[*Connection]struct{}),
+		done:        make(chan struct{}),
+		dropLogs:    logLimiter{interval: queueLogInterval},
+		queue:       make(chan queuedMessage, config.Queue.Size),
 	}
+	server.runtime.Store(&serverRuntime{conf: config})
 
 	server.setLogLevel()
 
-	if err := server.setRules(); err != nil {
+	runtime, err := newServerRuntime(config)
+	if err != nil {
+		return server, err
+	}
+	if err = server.initActions(runtime.ruleset); err != nil {
 		return server, err
 	}
+	server.runtime.Store(runtime)
+	defer func() {
+		if err == nil {
+			return
+		}
+		// The Server is not usable, so the workers that process replayed messages are
+		// drained and the initialized actions and the spool are closed again.
+		server.shutdownOnce.Do(func() {
+			close(server.done)
+		})
+		server.wg.Wait()
+		server.closeActions(runtime.ruleset)
+		if server.spool != nil {
+			if closeErr := server.spool.Close(); closeErr != nil {
+				server.log.Error("failed to close spool", LogErrKey, closeErr)
+			}
+		}
+	}()
 
-	parser, err := parsesyslog.New(server.conf.internal.ParserType)
-	if err != nil {
-		return server, fmt.Errorf("failed to initialize syslog parser: %w", err)
+	for i := range config.FileInputs {
+		tailer, err := newFileTailer(&config.FileInputs[i])
+		if err != nil {
+			return server, fmt.Errorf("failed to initialize file input %q: %w",
+				config.FileInputs[i].Name, err)
+		}
+		server.fileTailers = append(server.fileTailers, tailer)
 	}
-	server.parser = parser
 
 	if len(actions.Actions) <= 0 {
 		return server, fmt.Errorf("no action plugins found/configured")
 	}
 
+	if config.Spool.Enabled {
+		spool, err := newSpool(config.Spool.Path, config.Spool.SegmentSize, config.Spool.Sync)
+		if err != nil {
+			return server, err
+		}
+		server.spool = spool
+	}
+
+	server.startWorkers()
+	if config.Server.FlushInterval > 0 {
+		go server.flushActionsPeriodically(config.Server.FlushInterval)
+	}
+
+	if server.spool != nil {
+		if err := server.replaySpool(); err != nil {
+			return server, fmt.Errorf("failed to replay spool: %w", err)
+		}
+	}
 	return server, nil
 }
 
-// Run starts the logranger Server by creating a new listener using the NewListener
-// method and calling RunWithListener with the obtained listener.
+// Run starts the logranger Server by opening all configured listeners. If sockets
+// have been passed to the process via systemd socket activation, they are matched to
+// the listeners by name and used instead of opening a new socket. Otherwise, stream
+// listeners are created using the NewStreamListener method, packet-oriented listeners
+// using the NewPacketListener method. If any of the listeners fails to open, the
+// already opened listeners are closed again and an error is returned. Otherwise,
+// a PID file is created and all listeners and file inputs are served concurrently,
+// feeding the same ruleset. If Shutdown has been called before, ErrServerShutdown is
+// returned without opening any listener.
 func (s *Server) Run() error {
-	listener, err := NewListener(s.conf)
+	return s.run(nil)
+}
+
+// RunWithListener starts the logranger Server like Run, but serves the given
+// listener for the first stream listener in the config instead of opening a new
+// socket for it. The given listener is used as is, it is neither wrapped for the
+// PROXY protocol nor for TLS. If the config does not contain a stream listener, an
+// error is returned.
+func (s *Server) RunWithListener(listener net.Listener) error {
+	if listener == nil {
+		return errors.New("no listener given")
+	}
+	return s.run(listener)
+}
+
+// run opens and serves all configured listeners as described for Run. If a listener
+// is given, it is used for the first stream listener instead of opening a new socket.
+func (s *Server) run(listener net.Listener) error {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+	if s.shuttingDown() {
+		return ErrServerShutdown
+	}
+	listeners := s.runtime.Load().listeners
+	var provided *listenerInstance
+	if listener != nil {
+		if provided = streamListener(listeners); provided == nil {
+			return errors.New("no stream listener configured")
+		}
+	}
+
+	sockets, err := ActivatedSockets()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read sockets passed by systemd: %w", err)
 	}
-	return s.RunWithListener(listener)
+	if len(listeners) == 1 && len(sockets) == 1 && sockets[listenFDNameUnknown] != nil {
+		sockets[listeners[0].conf.Name] = sockets[listenFDNameUnknown]
+		delete(sockets, listenFDNameUnknown)
+	}
+
+	for _, instance := range listeners {
+		var openErr error
+		socket, activated := sockets[instance.conf.Name]
+		switch {
+		case instance == provided:
+			instance.useListener(listener)
+		case activated:
+			s.log.Info("using socket passed by systemd", slog.String("listener", instance.conf.Name))
+			openErr = instance.openFile(socket)
+			delete(sockets, instance.conf.Name)
+		default:
+			openErr = instance.open()
+		}
+		if openErr != nil {
+			for _, opened := range listeners {
+				opened.close()
+			}
+			for _, socket := range sockets {
+				_ = socket.Close()
+			}
+			return fmt.Errorf("failed to open listener %q: %w", instance.conf.Name, openErr)
+		}
+	}
+	for name, socket := range sockets {
+		s.log.Warn("socket passed by systemd does not match any listener, closing it",
+			slog.String("socket_name", name))
+		_ = socket.Close()
+	}
+
+	s.createPIDFile()
+
+	for _, instance := range listeners {
+		s.serveListener(instance)
+	}
+	for _, tailer := range s.fileTailers {
+		s.wg.Add(1)
+		go s.tailFiles(tailer)
+	}
+
+	return nil
 }
 
-// RunWithListener sets the listener for the server and performs some additional
-// tasks for initializing the server. It creates a PID file, writes the process ID
-// to the file, and listens for connections. It returns an error if any of the
-// initialization steps fail.
-func (s *Server) RunWithListener(listener net.Listener) error {
-	s.listener = listener
+// serveListener starts serving the given opened listener in a new goroutine. For
+// TLS listeners, the certificate watcher is started as well.
+func (s *Server) serveListener(instance *listenerInstance) {
+	if certs := instance.certReloader(); certs != nil {
+		go certs.Watch(instance.conf.CertReloadInterval, func(err error) {
+			s.log.Error("failed to reload TLS certificate", LogErrKey, err,
+				slog.String("listener", instance.conf.Name))
+		})
+	}
+	s.wg.Add(1)
+	switch {
+	case instance.packetConn != nil:
+		go s.listenPacket(instance)
+	case instance.conf.Type.IsHTTPListener():
+		instance.httpServer = s.newHTTPServer(instance)
+		go s.listenHTTP(instance)
+	default:
+		go s.listen(instance)
+	}
+}
+
+// stopListener closes the given listener, so that no new connections, datagrams or
+// HTTP requests are accepted anymore. Connections and HTTP requests in progress are
+// completed in the background.
+func (s *Server) stopListener(instance *listenerInstance) {
+	instance.close()
+	if instance.httpServer != nil {
+		go func() {
+			_ = instance.httpServer.Shutdown(context.Background())
+		}()
+	}
+}
 
-	// Create PID file
-	pidFile, err := os.Create(s.conf.Server.PIDFile)
+// createPIDFile creates the PID file configured in the Server config and writes
+// the process ID to it.
+func (s *Server) createPIDFile() {
+	pidFile, err := os.Create(s.config().Server.PIDFile)
 	if err != nil {
 		s.log.Error("failed to create PID file", LogErrKey, err)
 		os.Exit(1)
 	}
+	s.pidFile = pidFile.Name()
 	pid := os.Getpid()
 	s.log.Debug("creating PID file", slog.String("pid_file", pidFile.Name()),
 		slog.Int("pid", pid))
@@ -103,38 +290,128 @@ func (s *Server) RunWithListener(listener net.Listener) error {
 	if err = pidFile.Close(); err != nil {
 		s.log.Error("failed to close PID file", LogErrKey, err)
 	}
+}
 
-	// Listen for connections
+// Listen handles incoming connections of the first stream listener and processes log
+// messages. The listener must have been opened by Run or RunWithListener before.
+// Listen blocks until the listener is closed.
+//
+// Deprecated: Run serves all configured listeners concurrently, calling Listen is not
+// required anymore.
+func (s *Server) Listen() {
+	instance := streamListener(s.runtime.Load().listeners)
+	if instance == nil || instance.listener == nil || instance.conf.Type.IsHTTPListener() {
+		s.log.Error("failed to listen for new connections", LogErrKey,
+			errors.New("no opened stream listener found"))
+		return
+	}
 	s.wg.Add(1)
-	go s.Listen()
-
-	return nil
+	s.listen(instance)
 }
 
-// Listen handles incoming connections and processes log messages.
-func (s *Server) Listen() {
+// listen handles incoming connections of the given listener and processes log messages.
+// Connections from sources that are not allowed by the access lists of the listener
+// and connections exceeding the configured connection limits are refused. After an
+// accept error, accepting new connections is delayed with an increasing backoff. listen
+// returns when the listener is closed.
+func (s *Server) listen(instance *listenerInstance) {
 	defer s.wg.Done()
-	s.log.Info("listening for new connections", slog.String("listen_addr", s.listener.Addr().String()))
+	s.log.Info("listening for new connections", slog.String("listener", instance.conf.Name),
+		slog.String("listen_addr", instance.listener.Addr().String()))
+	var acceptDelay time.Duration
 	for {
-		acceptConn, err := s.listener.Accept()
+		acceptConn, err := instance.listener.Accept()
 		if err != nil {
-			s.log.Error("failed to accept new connection", LogErrKey, err)
+			if s.shuttingDown() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			acceptDelay = min(max(2*acceptDelay, minAcceptDelay), maxAcceptDelay)
+			s.log.Error("failed to accept new connection", LogErrKey, err,
+				slog.String("listener", instance.conf.Name),
+				slog.Duration("retry_in", acceptDelay))
+			select {
+			case <-s.done:
+				return
+			case <-time.After(acceptDelay):
+			}
+			continue
+		}
+		acceptDelay = 0
+		if !instance.limits.Acquire() {
+			s.log.Warn("connection refused, maximum number of connections reached",
+				slog.String("listener", instance.conf.Name),
+				slog.String("remote_addr", acceptConn.RemoteAddr().String()),
+				slog.Uint64("max_connections", uint64(instance.conf.MaxConnections)))
+			_ = acceptConn.Close()
 			continue
 		}
-		s.log.Debug("accepted new connection",
+		s.log.Debug("accepted new connection", slog.String("listener", instance.conf.Name),
 			slog.String("remote_addr", acceptConn.RemoteAddr().String()))
 		connection := NewConnection(acceptConn)
+		connection.listener = instance
 		s.wg.Add(1)
 		go func(co *Connection) {
-			s.HandleConnection(co)
-			s.wg.Done()
+			defer s.wg.Done()
+			defer instance.limits.Release()
+			s.trackConnection(co, true)
+			defer s.trackConnection(co, false)
+			if !s.admitConnection(co) {
+				return
+			}
+			defer instance.limits.ReleaseAddr(co.conn.RemoteAddr())
+			switch instance.conf.Type {
+			case ListenerRELP:
+				s.handleRELPConnection(co)
+			case ListenerGELFTCP:
+				s.handleGELFConnection(co)
+			default:
+				s.HandleConnection(co)
+			}
 		}(connection)
 	}
 }
 
+// admitConnection reads the PROXY protocol header of the given connection, if enabled
+// for the listener, and checks the access lists and the per-IP connection limit for
+// the remote address of the client. Behind a proxy, this is the address of the
+// original client given in the PROXY protocol header. If the connection is not
+// admitted, it is closed and false is returned. Otherwise, the caller must release
+// the per-IP slot once the connection is done.
+func (s *Server) admitConnection(connection *Connection) bool {
+	instance := connection.listener
+	err := connection.conn.SetDeadline(time.Now().Add(instance.conf.ReadTimeout))
+	if err == nil {
+		err = connection.readProxyHeader()
+	}
+	if err != nil {
+		s.log.Error("connection handshake failed", LogErrKey, err,
+			slog.String("remote_addr", connection.conn.RemoteAddr().String()))
+		_ = connection.conn.Close()
+		return false
+	}
+	if !s.checkAccess(instance, connection.conn.RemoteAddr()) {
+		_ = connection.conn.Close()
+		return false
+	}
+	if !instance.limits.AcquireAddr(connection.conn.RemoteAddr()) {
+		s.log.Warn("connection refused, maximum number of connections per IP reached",
+			slog.String("listener", instance.conf.Name),
+			slog.String("remote_addr", connection.conn.RemoteAddr().String()),
+			slog.Uint64("max_connections_per_ip", uint64(instance.conf.MaxConnectionsPerIP)))
+		_ = connection.conn.Close()
+		return false
+	}
+	return true
+}
+
 // HandleConnection handles a single connection by parsing and processing log messages.
-// It logs debug information about the connection and measures the processing time.
-// It closes the connection when done, and logs any error encountered during the process.
+// Each message is read from the connection based on the configured RFC6587 framing
+// method before it is handed to the parser, so that messages with embedded newlines
+// stay intact. Messages exceeding the maximum message size of the listener are
+// truncated or dropped based on its oversize policy, dropping closes the connection.
+// It closes the connection when done, and logs any error encountered during the
+// process. Connections created with NewConnection are handled with the settings of
+// the first stream listener in the config.
 func (s *Server) HandleConnection(connection *Connection) {
 	defer func() {
 		if err := connection.conn.Close(); err != nil {
@@ -142,71 +419,267 @@ func (s *Server) HandleConnection(connection *Connection) {
 		}
 	}()
 
+	instance := connection.listener
+	if instance == nil {
+		if instance = streamListener(s.runtime.Load().listeners); instance == nil {
+			s.log.Error("failed to handle connection", LogErrKey, errors.New("no stream listener configured"))
+			return
+		}
+		connection.listener = instance
+	}
+	if err := connection.conn.SetDeadline(time.Now().Add(instance.conf.ReadTimeout)); err != nil {
+		s.log.Error("failed to set processing deadline", LogErrKey, err,
+			slog.Duration("timeout", instance.conf.ReadTimeout))
+		return
+	}
+	if err := connection.Handshake(); err != nil {
+		s.log.Error("connection handshake failed", LogErrKey, err,
+			slog.String("remote_addr", connection.conn.RemoteAddr().String()))
+		return
+	}
+
+	framing := instance.conf.Framing
 ReadLoop:
 	for {
-		if err := connection.conn.SetDeadline(time.Now().Add(s.conf.Parser.Timeout)); err != nil {
-			s.log.Error("failed to set processing deadline", LogErrKey, err,
-				slog.Duration("timeout", s.conf.Parser.Timeout))
+		if s.shuttingDown() && connection.rb.Buffered() == 0 {
 			return
 		}
-		logMessage, err := s.parser.ParseReader(connection.rb)
+		err := connection.awaitData()
+		var frame []byte
+		if err == nil {
+			frame, err = ReadFrame(connection.rb, framing, int(instance.conf.MaxMessageSize),
+				instance.conf.OversizePolicy)
+		}
+		if errors.Is(err, ErrMessageTooLarge) {
+			var keep bool
+			frame, keep = s.oversizeMessage(instance, connection.conn.RemoteAddr().String(), frame)
+			if !keep {
+				return
+			}
+			err = nil
+		}
 		if err != nil {
 			var netErr *net.OpError
 			switch {
 			case errors.As(err, &netErr):
-				if s.conf.Log.Extended {
+				if s.config().Log.Extended {
 					s.log.Error("network error while processing message", LogErrKey,
 						netErr.Error())
 				}
 				return
-			case errors.Is(err, io.EOF):
-				if s.conf.Log.Extended {
+			case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+				if s.config().Log.Extended {
 					s.log.Error("message could not be processed", LogErrKey,
 						"EOF received")
 				}
 				return
 			default:
-				s.log.Error("failed to parse message", LogErrKey, err,
-					slog.String("parser_type", s.conf.Parser.Type))
-				continue ReadLoop
+				s.log.Error("failed to read message frame", LogErrKey, err,
+					slog.String("framing", framing.String()))
+				return
 			}
 		}
-		s.wg.Add(1)
-		go s.processMessage(logMessage)
+		logMessage, err := instance.parser.Parse(frame, connection.conn.RemoteAddr())
+		if err != nil {
+			s.log.Error("failed to parse message", LogErrKey, err,
+				slog.String("listener", instance.conf.Name),
+				slog.String("parser_type", instance.conf.Parser))
+			continue ReadLoop
+		}
+		_ = s.dispatchMessage(logMessage, connection.meta)
+	}
+}
+
+// oversizeMessage logs a message received by the given listener that exceeds the
+// maximum message size. If the listener truncates oversize messages, the truncated
+// frame is returned with the TruncationMarker appended. Otherwise, false is returned
+// and the message must be dropped.
+func (s *Server) oversizeMessage(instance *listenerInstance, remoteAddr string, frame []byte) ([]byte, bool) {
+	s.log.Warn("message exceeds maximum message size",
+		slog.String("listener", instance.conf.Name),
+		slog.String("remote_addr", remoteAddr),
+		slog.Uint64("max_message_size", uint64(instance.conf.MaxMessageSize)),
+		slog.String("oversize_policy", instance.conf.OversizePolicy.String()))
+	if instance.conf.OversizePolicy == OversizeDrop {
+		return nil, false
+	}
+	return append(frame, TruncationMarker...), true
+}
+
+// listenPacket reads incoming datagrams from the given packet-oriented listener and
+// processes each datagram as a single log message, as described in RFC5426. Datagrams
+// of GELF listeners are handed to handleGELFDatagram instead. Datagrams from sources
+// that are not allowed by the access lists of the listener are discarded. For UNIX
+// datagram listeners, the credentials of the sending process are attached to each
+// message as metadata.
+func (s *Server) listenPacket(instance *listenerInstance) {
+	defer s.wg.Done()
+	s.log.Info("listening for new datagrams", slog.String("listener", instance.conf.Name),
+		slog.String("listen_addr", instance.packetConn.LocalAddr().String()))
+	buffer := make([]byte, MaxDatagramSize)
+	oob := make([]byte, credentialsOOBSize)
+	for {
+		length, remoteAddr, meta, err := readDatagram(instance.packetConn, buffer, oob)
+		if err != nil {
+			if s.shuttingDown() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.log.Error("failed to read datagram", LogErrKey, err,
+				slog.String("listener", instance.conf.Name))
+			continue
+		}
+		if !s.checkAccess(instance, remoteAddr) {
+			continue
+		}
+		if instance.conf.Type == ListenerGELFUDP {
+			s.handleGELFDatagram(instance, buffer[:length], remoteAddr)
+			continue
+		}
+		s.handleDatagram(instance, buffer[:length], remoteAddr, meta)
+	}
+}
+
+// readDatagram reads a single datagram from the given net.PacketConn into the given
+// buffer. On UNIX datagram sockets, the credentials of the sender are read from the
+// out-of-band data and returned as metadata.
+func readDatagram(packetConn net.PacketConn, buffer, oob []byte) (int, net.Addr, map[string]string, error) {
+	unixConn, ok := packetConn.(*net.UnixConn)
+	if !ok || len(oob) == 0 {
+		length, remoteAddr, err := packetConn.ReadFrom(buffer)
+		return length, remoteAddr, nil, err
+	}
+	length, oobLength, _, unixAddr, err := unixConn.ReadMsgUnix(buffer, oob)
+	if err != nil {
+		return length, nil, nil, err
+	}
+	var remoteAddr net.Addr
+	if unixAddr != nil {
+		remoteAddr = unixAddr
+	}
+	credentials, ok := datagramPeerCredentials(oob[:oobLength])
+	if !ok {
+		return length, remoteAddr, nil, nil
+	}
+	meta := make(map[string]string)
+	credentials.setMetadata(meta)
+	return length, remoteAddr, meta, nil
+}
+
+// handleDatagram parses a single datagram received by a packet-oriented listener
+// and hands the resulting log message over for processing, together with the given
+// receiver-side metadata. Since RFC5426 maps exactly one syslog message to one
+// datagram, no further framing is required.
+func (s *Server) handleDatagram(instance *listenerInstance, datagram []byte, remoteAddr net.Addr,
+	meta map[string]string,
+) {
+	logMessage, err := instance.parser.Parse(datagram, remoteAddr)
+	if err != nil {
+		s.log.Error("failed to parse message", LogErrKey, err,
+			slog.String("listener", instance.conf.Name),
+			slog.String("parser_type", instance.conf.Parser),
+			slog.String("remote_addr", addrString(remoteAddr)))
+		return
+	}
+	_ = s.dispatchMessage(logMessage, meta)
+}
+
+// addrString returns the string representation of the given net.Addr. Senders on
+// UNIX datagram sockets are usually unnamed, in which case no address is returned
+// by the socket.
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return "unnamed"
+	}
+	return addr.String()
+}
+
+// dispatchMessage attaches the given receiver-side metadata to the log message and
+// puts it into the processing queue, from which it is taken by the workers. If the
+// spool is enabled, the message is written to the spool first. If the queue is full,
+// the configured queue policy either blocks until the queue has room for the message
+// or drops the message.
+// It returns an error if the message could not be accepted for processing. The error
+// has already been logged, with dropped messages being logged at most once per
+// queueLogInterval. Listeners that acknowledge messages to the sender must only do
+// so if no error is returned.
+func (s *Server) dispatchMessage(logMessage parsesyslog.LogMsg, meta map[string]string) error {
+	for key, value := range meta {
+		metadata.Set(&logMessage, key, value)
+	}
+	return s.enqueueMessage(logMessage, s.config().Queue.Policy)
+}
+
+// enqueueMessage writes the log message to the spool, if enabled, and puts it into
+// the processing queue according to the given queue policy. Messages that are
+// dropped are removed from the spool again.
+func (s *Server) enqueueMessage(logMessage parsesyslog.LogMsg, policy QueuePolicy) error {
+	message := queuedMessage{logMessage: logMessage}
+	if s.spool != nil {
+		ref, err := s.spool.Write(logMessage)
+		if err != nil {
+			s.log.Error("failed to write message to spool", LogErrKey, err)
+			return err
+		}
+		message.spoolRef = ref
+	}
+	s.wg.Add(1)
+	if policy == QueueBlock {
+		s.queue <- message
+		return nil
+	}
+	select {
+	case s.queue <- message:
+		return nil
+	default:
+		s.wg.Done()
+		s.ackMessage(message, true)
+		s.dropped.Add(1)
+		if shouldLog, suppressed := s.dropLogs.Allow(); shouldLog {
+			s.log.Warn("processing queue is full, dropping message",
+				slog.Int("queue_size", s.config().Queue.Size),
+				slog.Uint64("dropped_total", s.dropped.Load()),
+				slog.Uint64("suppressed", suppressed))
+		}
+		return ErrQueueFull
 	}
 }
 
 // processMessage processes a log message by matching it against the ruleset and executing
 // the corresponding actions if a match is found. It takes a parsesyslog.LogMsg as input
-// and returns an error if there was an error while processing the actions.
+// and returns true if all actions have been processed successfully.
 // The method first checks if the ruleset is not nil. If it is nil, no actions will be
 // executed. For each rule in the ruleset, it checks if the log message matches the
-// rule's regular expression.
-func (s *Server) processMessage(logMessage parsesyslog.LogMsg) {
-	defer s.wg.Done()
-	if s.ruleset != nil {
-		for _, rule := range s.ruleset.Rule {
+// rule's regular expression and processes it with the action instances of the rule,
+// which have been configured when the ruleset was loaded.
+//
+// processMessage is called by the workers for each message taken from the queue.
+func (s *Server) processMessage(logMessage parsesyslog.LogMsg) bool {
+	success := true
+	current := s.acquireRuntime()
+	defer current.release()
+	if current.ruleset != nil {
+		for _, rule := range current.ruleset.Rule {
 			if !rule.Regexp.MatchString(logMessage.Message.String()) {
 				continue
 			}
-			if rule.HostMatch != nil && !rule.HostMatch.MatchString(logMessage.Hostname) {
+			if rule.HostMatch != nil && !rule.HostMatch.MatchString(logMessage.Hostname()) {
+				continue
+			}
+			if !rule.MatchMetadata(logMessage) {
 				continue
 			}
 			matchGroup := rule.Regexp.FindStringSubmatch(logMessage.Message.String())
-			for name, action := range actions.Actions {
+			for _, ruleAction := range rule.actions {
+				name := ruleAction.name
 				startTime := time.Now()
-				if err := action.Config(rule.Actions); err != nil {
-					s.log.Error("failed to config action", LogErrKey, err,
-						slog.String("action", name), slog.String("rule_id", rule.ID))
-					continue
-				}
 				s.log.Debug("log message matches rule, executing action",
 					slog.String("action", name), slog.String("rule_id", rule.ID))
-				if err := action.Process(logMessage, matchGroup); err != nil {
+				if err := ruleAction.action.Process(logMessage, matchGroup); err != nil {
 					s.log.Error("failed to process action", LogErrKey, err,
 						slog.String("action", name), slog.String("rule_id", rule.ID))
+					success = false
 				}
-				if s.conf.Log.Extended {
+				if current.conf.Log.Extended {
 					procTime := time.Since(startTime)
 					s.log.Debug("action processing benchmark",
 						slog.Duration("processing_time", procTime),
@@ -216,18 +689,117 @@ func (s *Server) processMessage(logMessage parsesyslog.LogMsg) {
 			}
 		}
 	}
+	return success
+}
+
+// Shutdown gracefully shuts down the Server. It closes all listeners, so that no new
+// connections, datagrams or HTTP requests are accepted, and stops the file inputs.
+// Open connections are closed once the messages that have already been received are
+// processed, HTTP requests in progress are completed. Shutdown then waits for all
+// connections and actions in progress to finish, until the given context is done,
+// and closes the actions. If the context is done first, the remaining connections
+// are closed forcefully and an error is returned. The PID file is removed in any case.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		close(s.done)
+	})
+	defer s.removePIDFile()
+
+	s.reloadMutex.Lock()
+	listeners := s.runtime.Load().listeners
+	s.reloadMutex.Unlock()
+	for _, instance := range listeners {
+		if instance.httpServer != nil {
+			if err := instance.httpServer.Shutdown(ctx); err != nil {
+				s.log.Error("failed to shut down HTTP listener", LogErrKey, err,
+					slog.String("listener", instance.conf.Name))
+			}
+			continue
+		}
+		instance.close()
+	}
+
+	s.connMutex.Lock()
+	for connection := range s.connections {
+		_ = connection.conn.SetReadDeadline(time.Now())
+	}
+	s.connMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		s.reloadMutex.Lock()
+		current := s.runtime.Load()
+		current.active.Lock()
+		s.closeActions(current.ruleset)
+		current.active.Unlock()
+		s.reloadMutex.Unlock()
+		if s.spool != nil {
+			if err := s.spool.Close(); err != nil {
+				return fmt.Errorf("failed to close spool: %w", err)
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		s.connMutex.Lock()
+		for connection := range s.connections {
+			_ = connection.conn.Close()
+		}
+		s.connMutex.Unlock()
+		return fmt.Errorf("failed to drain connections and actions: %w", ctx.Err())
+	}
+}
+
+// shuttingDown returns true if Shutdown has been called
+func (s *Server) shuttingDown() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// trackConnection adds the given connection to or removes it from the open connections
+// of the Server. If the Server is shutting down, new connections are interrupted right
+// away, so they do not wait for further messages.
+func (s *Server) trackConnection(connection *Connection, open bool) {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+	if !open {
+		delete(s.connections, connection)
+		return
+	}
+	s.connections[connection] = struct{}{}
+	if s.shuttingDown() {
+		_ = connection.conn.SetReadDeadline(time.Now())
+	}
 }
 
-// setLogLevel sets the log level based on the value of `s.conf.Log.Level`.
+// removePIDFile removes the PID file that has been created by Run
+func (s *Server) removePIDFile() {
+	if s.pidFile == "" {
+		return
+	}
+	if err := os.Remove(s.pidFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.log.Error("failed to remove PID file", LogErrKey, err)
+	}
+}
+
+// setLogLevel sets the log level based on the value of `Log.Level` of the config.
 // It creates a new `slog.HandlerOptions` and assigns the corresponding `slog.Level`
-// based on the value of `s.conf.Log.Level`. If the value is not one of the valid levels,
+// based on the value of `Log.Level`. If the value is not one of the valid levels,
 // `info` is used as the default level.
 // It then creates a new `slog.JSONHandler` with `os.Stdout` and the handler options.
 // Finally, it creates a new `slog.Logger` with the JSON handler and sets the `s.log` field
 // of the `Server` struct to the logger, with a context value of "logranger".
 func (s *Server) setLogLevel() {
 	logOpts := slog.HandlerOptions{}
-	switch strings.ToLower(s.conf.Log.Level) {
+	switch strings.ToLower(s.config().Log.Level) {
 	case "debug":
 		logOpts.Level = slog.LevelDebug
 	case "info":
@@ -242,35 +814,3 @@ func (s *Server) setLogLevel() {
 	logHandler := slog.NewJSONHandler(os.Stdout, &logOpts)
 	s.log = slog.New(logHandler).With(slog.String("context", "logranger"))
 }
-
-// setRules initializes/updates the ruleset for the logranger Server by
-// calling NewRuleset with the config and assigns the returned ruleset
-// to the Server's ruleset field.
-// It returns an error if there is a failure in reading or loading the ruleset.
-func (s *Server) setRules() error {
-	ruleset, err := NewRuleset(s.conf)
-	if err != nil {
-		return fmt.Errorf("failed to read ruleset: %w", err)
-	}
-	s.ruleset = ruleset
-	return nil
-}
-
-// ReloadConfig reloads the configuration of the Server with the specified
-// path and filename.
-// It creates a new Config using the NewConfig method and updates the Server's
-// conf field. It also reloads the configured Ruleset.
-// If an error occurs while reloading the configuration, an error is returned.
-func (s *Server) ReloadConfig(path, file string) error {
-	config, err := NewConfig(path, file)
-	if err != nil {
-		return fmt.Errorf("failed to reload config: %w", err)
-	}
-	s.conf = config
-
-	if err := s.setRules(); err != nil {
-		return fmt.Errorf("failed to reload ruleset: %w", err)
-	}
-
-	return nil
-}
@@ -0,0 +1,452 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wneessen/go-parsesyslog"
+)
+
+const (
+	// spoolSegmentExt is the file extension of spool segment files
+	spoolSegmentExt = ".seg"
+	// spoolAckExt is the file extension of the acknowledgement files of spool segments
+	spoolAckExt = ".ack"
+	// spoolCorruptExt is appended to the names of spool segments that could not be
+	// replayed completely because they are corrupt
+	spoolCorruptExt = ".corrupt"
+	// spoolHeaderLen is the length of the header of a spool record, consisting of the
+	// length and the CRC-32 checksum of the record data
+	spoolHeaderLen = 8
+	// spoolMaxRecordLen is the maximum length of the data of a single spool record
+	spoolMaxRecordLen = 64 * 1024 * 1024
+)
+
+// spoolCRCTable is the CRC-32 (Castagnoli) table used for the checksums of spool records
+var spoolCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errSpoolSegmentCorrupt is returned if a spool segment contains an invalid record
+var errSpoolSegmentCorrupt = errors.New("spool segment is corrupt")
+
+// spool is a disk-backed write-ahead log for accepted log messages. Messages are
+// appended to segment files before they are queued for processing. Once all actions
+// for a message have succeeded, the message is acknowledged in the acknowledgement
+// file of its segment. Segments are removed when all of their messages have been
+// acknowledged. Segments that remain after a crash or restart are replayed on startup.
+type spool struct {
+	current     *spoolSegment
+	dir         string
+	mutex       sync.Mutex
+	nextSeq     uint64
+	segmentSize int64
+	sync        bool
+}
+
+// spoolSegment is a single segment file of the spool
+type spoolSegment struct {
+	ackFile  *os.File
+	closed   bool
+	failed   bool
+	file     *os.File
+	finished uint32
+	records  uint32
+	seq      uint64
+	size     int64
+}
+
+// spoolRef references a single record in the spool
+type spoolRef struct {
+	index   uint32
+	segment *spoolSegment
+}
+
+// spoolRecord is the representation of a log message in the spool
+type spoolRecord struct {
+	App            []byte                              `json:"app"`
+	Facility       parsesyslog.Facility                `json:"facility"`
+	HasBOM         bool                                `json:"has_bom"`
+	Host           []byte                              `json:"host"`
+	Message        []byte                              `json:"message"`
+	MsgID          []byte                              `json:"msg_id"`
+	MsgLength      int32                               `json:"msg_length"`
+	PID            []byte                              `json:"pid"`
+	Priority       parsesyslog.Priority                `json:"priority"`
+	ProtoVersion   parsesyslog.ProtoVersion            `json:"proto_version"`
+	Severity       parsesyslog.Severity                `json:"severity"`
+	StructuredData []parsesyslog.StructuredDataElement `json:"structured_data"`
+	Timestamp      time.Time                           `json:"timestamp"`
+	Type           parsesyslog.LogMsgType              `json:"type"`
+}
+
+// newSpool opens the spool in the given directory. The directory is created if it
+// does not exist. New segments are numbered after the existing ones, so that the
+// existing segments can be replayed.
+func newSpool(dir string, segmentSize int64, sync bool) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	spool := &spool{dir: dir, segmentSize: segmentSize, sync: sync}
+	segments, err := spool.segmentSeqs()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		spool.nextSeq = segments[len(segments)-1] + 1
+	}
+	return spool, nil
+}
+
+// Write appends the given log message to the current segment of the spool and
+// returns a reference to the record, which is used to acknowledge the message.
+func (s *spool) Write(logMessage parsesyslog.LogMsg) (*spoolRef, error) {
+	data, err := json.Marshal(newSpoolRecord(logMessage))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode spool record: %w", err)
+	}
+	record := make([]byte, spoolHeaderLen+len(data))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(data, spoolCRCTable))
+	copy(record[spoolHeaderLen:], data)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.current == nil || s.current.size+int64(len(record)) > s.segmentSize {
+		if err = s.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	segment := s.current
+	if _, err = segment.file.Write(record); err != nil {
+		return nil, fmt.Errorf("failed to write spool record: %w", err)
+	}
+	if s.sync {
+		if err = segment.file.Sync(); err != nil {
+			return nil, fmt.Errorf("failed to sync spool segment: %w", err)
+		}
+	}
+	segment.size += int64(len(record))
+	segment.records++
+	return &spoolRef{index: segment.records - 1, segment: segment}, nil
+}
+
+// Ack marks the referenced record as finished. If success is true, the record is
+// acknowledged and will not be replayed. Otherwise, the record is kept for replay on
+// the next start. A segment is removed once it is closed and all of its records
+// have been acknowledged.
+func (s *spool) Ack(ref *spoolRef, success bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	segment := ref.segment
+	segment.finished++
+	if !success {
+		segment.failed = true
+	} else {
+		index := make([]byte, 4)
+		binary.LittleEndian.PutUint32(index, ref.index)
+		if _, err := segment.ackFile.Write(index); err != nil {
+			return fmt.Errorf("failed to write spool acknowledgement: %w", err)
+		}
+	}
+	if !segment.closed {
+		return nil
+	}
+	return s.finishSegment(segment)
+}
+
+// Close closes the current segment of the spool. It is removed if all of its records
+// have been acknowledged.
+func (s *spool) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	segment := s.current
+	s.current = nil
+	segment.closed = true
+	if segment.finished < segment.records {
+		return segment.closeFiles()
+	}
+	return s.finishSegment(segment)
+}
+
+// Replay reads all segments that existed when the spool was opened and calls the
+// given function for each log message that has not been acknowledged. Replayed
+// messages are acknowledged in their segment, and each segment is removed once it
+// has been replayed completely, so the given function must write the messages to
+// the spool again. If the given function returns an error, Replay stops and returns
+// the error, keeping the segment for the next start. A segment with an invalid
+// record, e.g. a record that has only been partially written during a crash, is
+// renamed with the extension ".corrupt" for inspection instead of being removed.
+func (s *spool) Replay(log *slog.Logger, replay func(parsesyslog.LogMsg) error) error {
+	segments, err := s.segmentSeqs()
+	if err != nil {
+		return err
+	}
+	for _, seq := range segments {
+		if seq >= s.nextSeq {
+			break
+		}
+		path := s.segmentPath(seq)
+		acked, err := readSpoolAcks(path + spoolAckExt)
+		if err != nil {
+			return err
+		}
+		replayed, err := replaySegment(path, acked, replay)
+		log.Info("replayed spool segment", slog.String("segment", path),
+			slog.Int("messages", replayed))
+		if errors.Is(err, errSpoolSegmentCorrupt) {
+			log.Error("failed to replay spool segment completely, keeping it for inspection",
+				LogErrKey, err, slog.String("segment", path+spoolCorruptExt))
+			if err = os.Rename(path, path+spoolCorruptExt); err != nil {
+				return fmt.Errorf("failed to rename corrupt spool segment: %w", err)
+			}
+			err = os.Rename(path+spoolAckExt, path+spoolCorruptExt+spoolAckExt)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to rename acknowledgements of corrupt spool segment: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replay spool segment %q: %w", path, err)
+		}
+		if err = os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove replayed spool segment: %w", err)
+		}
+		if err = os.Remove(path + spoolAckExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove replayed spool acknowledgements: %w", err)
+		}
+	}
+	return nil
+}
+
+// rotate closes the current segment and opens a new one
+func (s *spool) rotate() error {
+	if s.current != nil {
+		segment := s.current
+		segment.closed = true
+		if err := segment.file.Close(); err != nil {
+			return fmt.Errorf("failed to close spool segment: %w", err)
+		}
+		segment.file = nil
+		if err := s.finishSegment(segment); err != nil {
+			return err
+		}
+	}
+
+	path := s.segmentPath(s.nextSeq)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+	ackFile, err := os.OpenFile(path+spoolAckExt, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to create spool acknowledgement file: %w", err)
+	}
+	s.current = &spoolSegment{ackFile: ackFile, file: file, seq: s.nextSeq}
+	s.nextSeq++
+	return nil
+}
+
+// finishSegment removes the given closed segment once all of its records have been
+// acknowledged. If processing of any of its records failed, the segment is kept for
+// replay on the next start and only its files are closed.
+func (s *spool) finishSegment(segment *spoolSegment) error {
+	if segment.finished < segment.records {
+		return nil
+	}
+	if segment.failed {
+		return segment.closeFiles()
+	}
+	if err := segment.closeFiles(); err != nil {
+		return fmt.Errorf("failed to close spool segment: %w", err)
+	}
+	path := s.segmentPath(segment.seq)
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove spool segment: %w", err)
+	}
+	if err := os.Remove(path + spoolAckExt); err != nil {
+		return fmt.Errorf("failed to remove spool acknowledgements: %w", err)
+	}
+	return nil
+}
+
+// closeFiles closes the open files of the segment
+func (s *spoolSegment) closeFiles() error {
+	var errs []error
+	if s.file != nil {
+		errs = append(errs, s.file.Close())
+		s.file = nil
+	}
+	if s.ackFile != nil {
+		errs = append(errs, s.ackFile.Close())
+		s.ackFile = nil
+	}
+	return errors.Join(errs...)
+}
+
+// segmentSeqs returns the sequence numbers of all segments in the spool directory
+// in ascending order
+func (s *spool) segmentSeqs() ([]uint64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	var segments []uint64
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), spoolSegmentExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// segmentPath returns the path of the segment file with the given sequence number
+func (s *spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+}
+
+// readSpoolAcks reads the indices of the acknowledged records from the given
+// acknowledgement file
+func readSpoolAcks(path string) (map[uint32]struct{}, error) {
+	acked := make(map[uint32]struct{})
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return acked, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool acknowledgements: %w", err)
+	}
+	for len(data) >= 4 {
+		acked[binary.LittleEndian.Uint32(data[:4])] = struct{}{}
+		data = data[4:]
+	}
+	return acked, nil
+}
+
+// replaySegment reads the records of the segment file at the given path and calls
+// the given function for each record that has not been acknowledged. Each replayed
+// record is acknowledged in the acknowledgement file of the segment. It returns the
+// number of replayed records. If the segment contains an invalid record, an error
+// wrapping errSpoolSegmentCorrupt is returned.
+func replaySegment(path string, acked map[uint32]struct{}, replay func(parsesyslog.LogMsg) error) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	ackFile, err := os.OpenFile(path+spoolAckExt, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open spool acknowledgement file: %w", err)
+	}
+	defer func() {
+		_ = ackFile.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, spoolHeaderLen)
+	index := make([]byte, 4)
+	replayed := 0
+	for recordIndex := uint32(0); ; recordIndex++ {
+		if _, err = io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return replayed, nil
+			}
+			return replayed, fmt.Errorf("%w: truncated record header: %w", errSpoolSegmentCorrupt, err)
+		}
+		length := binary.LittleEndian.Uint32(header[0:4])
+		if length > spoolMaxRecordLen {
+			return replayed, fmt.Errorf("%w: invalid record length: %d", errSpoolSegmentCorrupt, length)
+		}
+		data := make([]byte, length)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return replayed, fmt.Errorf("%w: truncated record: %w", errSpoolSegmentCorrupt, err)
+		}
+		if crc32.Checksum(data, spoolCRCTable) != binary.LittleEndian.Uint32(header[4:8]) {
+			return replayed, fmt.Errorf("%w: record checksum mismatch", errSpoolSegmentCorrupt)
+		}
+		if _, ok := acked[recordIndex]; ok {
+			continue
+		}
+		record := spoolRecord{}
+		if err = json.Unmarshal(data, &record); err != nil {
+			return replayed, fmt.Errorf("%w: failed to decode spool record: %w", errSpoolSegmentCorrupt, err)
+		}
+		if err = replay(record.LogMsg()); err != nil {
+			return replayed, err
+		}
+		binary.LittleEndian.PutUint32(index, recordIndex)
+		if _, err = ackFile.Write(index); err != nil {
+			return replayed, fmt.Errorf("failed to write spool acknowledgement: %w", err)
+		}
+		replayed++
+	}
+}
+
+// newSpoolRecord returns the spoolRecord for the given log message
+func newSpoolRecord(logMessage parsesyslog.LogMsg) spoolRecord {
+	return spoolRecord{
+		App:            logMessage.App,
+		Facility:       logMessage.Facility,
+		HasBOM:         logMessage.HasBOM,
+		Host:           logMessage.Host,
+		Message:        logMessage.Message.Bytes(),
+		MsgID:          logMessage.MsgID,
+		MsgLength:      logMessage.MsgLength,
+		PID:            logMessage.PID,
+		Priority:       logMessage.Priority,
+		ProtoVersion:   logMessage.ProtoVersion,
+		Severity:       logMessage.Severity,
+		StructuredData: logMessage.StructuredData,
+		Timestamp:      logMessage.Timestamp,
+		Type:           logMessage.Type,
+	}
+}
+
+// LogMsg returns the spoolRecord as parsesyslog.LogMsg
+func (r spoolRecord) LogMsg() parsesyslog.LogMsg {
+	logMessage := parsesyslog.LogMsg{
+		App:            r.App,
+		Facility:       r.Facility,
+		HasBOM:         r.HasBOM,
+		Host:           r.Host,
+		MsgID:          r.MsgID,
+		MsgLength:      r.MsgLength,
+		PID:            r.PID,
+		Priority:       r.Priority,
+		ProtoVersion:   r.ProtoVersion,
+		Severity:       r.Severity,
+		StructuredData: r.StructuredData,
+		Timestamp:      r.Timestamp,
+		Type:           r.Type,
+	}
+	logMessage.Message.Write(r.Message)
+	return logMessage
+}
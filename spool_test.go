@@ -0,0 +1,229 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wneessen/go-parsesyslog"
+	"github.com/wneessen/go-parsesyslog/rfc5424"
+)
+
+func TestSpoolRecord_LogMsg(t *testing.T) {
+	logMessage := parsesyslog.LogMsg{
+		App:          []byte("su"),
+		Facility:     4,
+		HasBOM:       true,
+		Host:         []byte("mymachine.example.com"),
+		MsgID:        []byte("ID47"),
+		MsgLength:    42,
+		PID:          []byte("1234"),
+		Priority:     34,
+		ProtoVersion: 1,
+		Severity:     2,
+		StructuredData: []parsesyslog.StructuredDataElement{{
+			ID:    []byte("exampleSDID@32473"),
+			Param: []parsesyslog.StructuredDataParam{{Key: []byte("iut"), Val: []byte("3")}},
+		}},
+		Timestamp: time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+		Type:      rfc5424.MsgType,
+	}
+	logMessage.Message.WriteString("'su root' failed for lonvick on /dev/pts/8")
+
+	data, err := json.Marshal(newSpoolRecord(logMessage))
+	if err != nil {
+		t.Fatalf("failed to encode spool record: %s", err)
+	}
+	record := spoolRecord{}
+	if err = json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("failed to decode spool record: %s", err)
+	}
+	got := record.LogMsg()
+	if !reflect.DeepEqual(newSpoolRecord(got), newSpoolRecord(logMessage)) {
+		t.Errorf("spool record round-trip mismatch:\ngot:  %+v\nwant: %+v", newSpoolRecord(got),
+			newSpoolRecord(logMessage))
+	}
+	if got.Hostname() != "mymachine.example.com" || got.AppName() != "su" || got.ProcID() != "1234" {
+		t.Errorf("spool record round-trip mismatch: host %q, app %q, pid %q", got.Hostname(),
+			got.AppName(), got.ProcID())
+	}
+}
+
+func TestSpool_Replay(t *testing.T) {
+	tests := []struct {
+		name        string
+		segmentSize int64
+		messages    int
+		acks        map[int]bool
+		wantReplay  []string
+	}{
+		{"all messages acknowledged", 1024 * 1024, 3, map[int]bool{0: true, 1: true, 2: true}, nil},
+		{
+			"unacknowledged messages", 1024 * 1024, 3, map[int]bool{0: true},
+			[]string{"message 1", "message 2"},
+		},
+		{
+			"failed messages", 1024 * 1024, 3, map[int]bool{0: true, 1: false, 2: true},
+			[]string{"message 1"},
+		},
+		{"no acknowledgements", 1024 * 1024, 2, nil, []string{"message 0", "message 1"}},
+		{
+			"multiple segments", 1, 4, map[int]bool{0: true, 1: false, 3: true},
+			[]string{"message 1", "message 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			spool := openTestSpool(t, dir, tt.segmentSize)
+			refs := make([]*spoolRef, tt.messages)
+			for i := range refs {
+				ref, err := spool.Write(spoolTestMessage(i))
+				if err != nil {
+					t.Fatalf("failed to write message %d: %s", i, err)
+				}
+				refs[i] = ref
+			}
+			for i, success := range tt.acks {
+				if err := spool.Ack(refs[i], success); err != nil {
+					t.Fatalf("failed to acknowledge message %d: %s", i, err)
+				}
+			}
+			if err := spool.Close(); err != nil {
+				t.Fatalf("failed to close spool: %s", err)
+			}
+
+			got := replayTestSpool(t, dir)
+			if !reflect.DeepEqual(got, tt.wantReplay) {
+				t.Errorf("replayed messages = %q, want %q", got, tt.wantReplay)
+			}
+			assertSpoolFiles(t, dir)
+			if got = replayTestSpool(t, dir); len(got) != 0 {
+				t.Errorf("messages have been replayed twice: %q", got)
+			}
+		})
+	}
+}
+
+func TestSpool_Replay_errors(t *testing.T) {
+	t.Run("replay function fails", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTestSpool(t, dir, 3)
+		replayErr := errors.New("queue closed")
+		calls := 0
+		spool := openTestSpool(t, dir, 1024*1024)
+		err := spool.Replay(discardLogger(), func(parsesyslog.LogMsg) error {
+			calls++
+			if calls == 2 {
+				return replayErr
+			}
+			return nil
+		})
+		if !errors.Is(err, replayErr) {
+			t.Fatalf("Replay returned error %v, want %v", err, replayErr)
+		}
+		got := replayTestSpool(t, dir)
+		if want := []string{"message 1", "message 2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("replayed messages = %q, want %q", got, want)
+		}
+		assertSpoolFiles(t, dir)
+	})
+	t.Run("corrupt segment", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeTestSpool(t, dir, 2)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("failed to stat spool segment: %s", err)
+		}
+		if err = os.Truncate(path, info.Size()-5); err != nil {
+			t.Fatalf("failed to truncate spool segment: %s", err)
+		}
+		got := replayTestSpool(t, dir)
+		if want := []string{"message 0"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("replayed messages = %q, want %q", got, want)
+		}
+		corrupt := filepath.Base(path) + spoolCorruptExt
+		assertSpoolFiles(t, dir, corrupt, corrupt+spoolAckExt)
+	})
+}
+
+// openTestSpool opens the spool in the given directory
+func openTestSpool(t *testing.T, dir string, segmentSize int64) *spool {
+	t.Helper()
+	spool, err := newSpool(dir, segmentSize, false)
+	if err != nil {
+		t.Fatalf("failed to open spool: %s", err)
+	}
+	return spool
+}
+
+// writeTestSpool writes the given number of unacknowledged messages to a single
+// segment in the given directory and returns the path of the segment
+func writeTestSpool(t *testing.T, dir string, messages int) string {
+	t.Helper()
+	spool := openTestSpool(t, dir, 1024*1024)
+	for i := 0; i < messages; i++ {
+		if _, err := spool.Write(spoolTestMessage(i)); err != nil {
+			t.Fatalf("failed to write message %d: %s", i, err)
+		}
+	}
+	if err := spool.Close(); err != nil {
+		t.Fatalf("failed to close spool: %s", err)
+	}
+	return spool.segmentPath(0)
+}
+
+// replayTestSpool opens the spool in the given directory, replays it and returns
+// the replayed messages
+func replayTestSpool(t *testing.T, dir string) []string {
+	t.Helper()
+	spool := openTestSpool(t, dir, 1024*1024)
+	var replayed []string
+	err := spool.Replay(discardLogger(), func(logMessage parsesyslog.LogMsg) error {
+		replayed = append(replayed, logMessage.Message.String())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to replay spool: %s", err)
+	}
+	return replayed
+}
+
+// assertSpoolFiles checks that the given directory contains exactly the given files
+func assertSpoolFiles(t *testing.T, dir string, want ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read spool directory: %s", err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+	if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+		t.Errorf("spool directory contains %q, want %q", got, want)
+	}
+}
+
+// discardLogger returns a slog.Logger that discards all log output
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// spoolTestMessage returns a log message with the given number in its message
+func spoolTestMessage(number int) parsesyslog.LogMsg {
+	logMessage := parsesyslog.LogMsg{Host: []byte("mymachine"), Timestamp: time.Now()}
+	logMessage.Message.WriteString(fmt.Sprintf("message %d", number))
+	return logMessage
+}
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/wneessen/logranger/metadata"
+)
+
+// ReaderStats holds the statistics of a ProcessReader run
+type ReaderStats struct {
+	// Messages is the number of messages that have been read
+	Messages uint
+	// ParseErrors is the number of messages that could not be parsed
+	ParseErrors uint
+}
+
+// ProcessReader reads newline-delimited syslog messages from the given reader until
+// EOF, e.g. from standard input, parses them with the configured global parser and
+// matches them against the ruleset. If no global parser is configured, the format of
+// each message is detected automatically. The raw parser uses the local hostname and
+// the facility "user" and severity "informational" for each message. Messages are
+// never dropped, regardless of the configured queue policy. ProcessReader returns
+// after all actions of the processed messages have finished.
+func (s *Server) ProcessReader(reader io.Reader) (ReaderStats, error) {
+	stats := ReaderStats{}
+	parserName := s.config().Parser.Type
+	if parserName == "" {
+		parserName = string(ParserAuto)
+	}
+	parserType, err := parserTypeFromString(parserName)
+	if err != nil {
+		return stats, err
+	}
+	parser, err := newMessageParser(parserType, parserOptions{facility: 1, severity: 6})
+	if err != nil {
+		return stats, fmt.Errorf("failed to initialize syslog parser: %w", err)
+	}
+	defer s.wg.Wait()
+
+	bufReader := bufio.NewReader(reader)
+	for {
+		frame, err := ReadFrame(bufReader, FramingLF, DefaultMaxMessageSize, OversizeTruncate)
+		if errors.Is(err, ErrMessageTooLarge) {
+			s.log.Warn("message exceeds maximum message size",
+				slog.Int("max_message_size", DefaultMaxMessageSize))
+			frame, err = append(frame, TruncationMarker...), nil
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return stats, nil
+			}
+			return stats, err
+		}
+		stats.Messages++
+		logMessage, err := parser.Parse(frame, nil)
+		if err != nil {
+			s.log.Error("failed to parse message", LogErrKey, err,
+				slog.String("parser_type", parserName))
+			stats.ParseErrors++
+			continue
+		}
+		metadata.Set(&logMessage, MetaInput, "stdin")
+		if err = s.enqueueMessage(logMessage, QueueBlock); err != nil {
+			return stats, err
+		}
+	}
+}
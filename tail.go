@@ -0,0 +1,302 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wneessen/go-parsesyslog"
+)
+
+const (
+	// MetaInput is the metadata key under which the type of the input a message was
+	// received on is stored
+	MetaInput = "input"
+	// MetaFilePath is the metadata key under which the path of the file a message was
+	// read from is stored
+	MetaFilePath = "file_path"
+)
+
+// fingerprintLen is the maximum amount of bytes at the beginning of a file that are
+// used to identify the file across restarts
+const fingerprintLen = 256
+
+// fileTailer follows the files matched by the globs of a file input, detects rotation
+// by rename and truncation and persists the read offsets of the files.
+type fileTailer struct {
+	conf     *FileInputConfig
+	files    map[string]*tailedFile
+	offsets  map[string]fileOffset
+	started  bool
+	hostname string
+}
+
+// tailedFile represents a single file that is followed by a fileTailer
+type tailedFile struct {
+	file   *os.File
+	info   os.FileInfo
+	offset int64
+}
+
+// fileOffset is the persisted read offset of a file. The fingerprint is a hash of
+// the first bytes of the file and is used to detect if the file at the path has been
+// replaced while logranger was not running.
+type fileOffset struct {
+	Offset         int64  `json:"offset"`
+	Fingerprint    string `json:"fingerprint"`
+	FingerprintLen int    `json:"fingerprint_len"`
+}
+
+// newFileTailer returns a new fileTailer for the given file input configuration and
+// loads the persisted read offsets
+func newFileTailer(conf *FileInputConfig) (*fileTailer, error) {
+	tailer := &fileTailer{
+		conf:     conf,
+		files:    make(map[string]*tailedFile),
+		offsets:  make(map[string]fileOffset),
+		hostname: conf.Hostname,
+	}
+	if tailer.hostname == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine hostname for file input: %w", err)
+		}
+		tailer.hostname = hostname
+	}
+	for _, pattern := range conf.Paths {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+		}
+	}
+
+	offsetData, err := os.ReadFile(conf.OffsetFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read offset file: %w", err)
+	default:
+		if err = json.Unmarshal(offsetData, &tailer.offsets); err != nil {
+			return nil, fmt.Errorf("failed to parse offset file: %w", err)
+		}
+	}
+	return tailer, nil
+}
+
+// tailFiles follows the files of the given fileTailer in the configured poll interval.
+// Each line that is read from a file is wrapped into a log message and handed over
+// for processing, the same way as messages received by a listener. tailFiles returns
+// when the Server is shut down.
+func (s *Server) tailFiles(tailer *fileTailer) {
+	defer s.wg.Done()
+	s.log.Info("following files", slog.String("input", tailer.conf.Name),
+		slog.Any("paths", tailer.conf.Paths))
+	ticker := time.NewTicker(tailer.conf.PollInterval)
+	defer ticker.Stop()
+	for {
+		tailer.poll(s.log, func(path string, line []byte) {
+			meta := map[string]string{MetaInput: "file", MetaFilePath: path}
+			_ = s.dispatchMessage(tailer.logMessage(line), meta)
+		})
+		select {
+		case <-s.done:
+			tailer.close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// close closes all files that are followed by the fileTailer. The read offsets have
+// already been persisted by the last poll.
+func (t *fileTailer) close() {
+	for path, tailed := range t.files {
+		_ = tailed.file.Close()
+		delete(t.files, path)
+	}
+}
+
+// logMessage wraps a single line read from a file into a synthetic log message
+// with the configured hostname, appname, facility and severity
+func (t *fileTailer) logMessage(line []byte) parsesyslog.LogMsg {
+	logMessage := parsesyslog.LogMsg{
+		App:       []byte(t.conf.AppName),
+		Facility:  parsesyslog.Facility(t.conf.Facility),
+		Host:      []byte(t.hostname),
+		Severity:  parsesyslog.Severity(t.conf.Severity),
+		Timestamp: time.Now(),
+	}
+	logMessage.Message.Write(line)
+	return logMessage
+}
+
+// poll expands the path globs of the file input, reads all new lines from the
+// matched files and persists the read offsets afterwards.
+func (t *fileTailer) poll(log *slog.Logger, handleLine func(string, []byte)) {
+	matched := make(map[string]struct{})
+	for _, pattern := range t.conf.Paths {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			matched[path] = struct{}{}
+		}
+	}
+
+	for path := range matched {
+		if err := t.follow(path, handleLine); err != nil {
+			log.Error("failed to read file", LogErrKey, err, slog.String("input", t.conf.Name),
+				slog.String("file_path", path))
+		}
+	}
+	for path, tailed := range t.files {
+		if _, ok := matched[path]; ok {
+			continue
+		}
+		t.readLines(path, tailed, true, handleLine)
+		_ = tailed.file.Close()
+		delete(t.files, path)
+		delete(t.offsets, path)
+	}
+	t.started = true
+
+	if err := t.saveOffsets(); err != nil {
+		log.Error("failed to save file offsets", LogErrKey, err, slog.String("input", t.conf.Name))
+	}
+}
+
+// follow reads all new lines from the file at the given path. If the file at the path
+// has been replaced (rotation by rename), the remaining lines of the old file are read
+// before the new file is opened. If the file has been truncated, it is read from the
+// beginning again.
+func (t *fileTailer) follow(path string, handleLine func(string, []byte)) error {
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if pathInfo.IsDir() {
+		return nil
+	}
+
+	tailed, ok := t.files[path]
+	if ok && !os.SameFile(tailed.info, pathInfo) {
+		t.readLines(path, tailed, true, handleLine)
+		_ = tailed.file.Close()
+		delete(t.files, path)
+		ok = false
+	}
+	if !ok {
+		if tailed, err = t.open(path); err != nil {
+			return err
+		}
+		t.files[path] = tailed
+	}
+
+	if pathInfo.Size() < tailed.offset {
+		tailed.offset = 0
+	}
+	tailed.info = pathInfo
+	t.readLines(path, tailed, false, handleLine)
+	return t.updateOffset(path, tailed)
+}
+
+// open opens the file at the given path and determines the offset to start reading
+// from. A persisted offset is only used if the fingerprint of the file still matches.
+// Files that already exist when the input is started are read from the end, unless
+// configured otherwise. Files that appear later (e.g. after a rotation) are read from
+// the beginning.
+func (t *fileTailer) open(path string) (*tailedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	tailed := &tailedFile{file: file, info: info}
+
+	if saved, ok := t.offsets[path]; ok {
+		fingerprint, err := fileFingerprint(file, saved.FingerprintLen)
+		if err == nil && fingerprint == saved.Fingerprint && saved.Offset <= info.Size() {
+			tailed.offset = saved.Offset
+			return tailed, nil
+		}
+	}
+	if !t.started && !t.conf.FromBeginning {
+		tailed.offset = info.Size()
+	}
+	return tailed, nil
+}
+
+// readLines reads all complete lines from the given file, starting at its current
+// offset, and advances the offset accordingly. An incomplete line at the end of the
+// file is only read if final is true, e.g. for a rotated file that is not written to
+// anymore.
+func (t *fileTailer) readLines(path string, tailed *tailedFile, final bool, handleLine func(string, []byte)) {
+	if _, err := tailed.file.Seek(tailed.offset, io.SeekStart); err != nil {
+		return
+	}
+	reader := bufio.NewReader(tailed.file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && (!final || len(line) == 0) {
+			return
+		}
+		tailed.offset += int64(len(line))
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			handleLine(path, line)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// updateOffset updates the read offset and the fingerprint of the given file
+func (t *fileTailer) updateOffset(path string, tailed *tailedFile) error {
+	length := int(min(tailed.info.Size(), fingerprintLen))
+	fingerprint, err := fileFingerprint(tailed.file, length)
+	if err != nil {
+		return err
+	}
+	t.offsets[path] = fileOffset{Offset: tailed.offset, Fingerprint: fingerprint, FingerprintLen: length}
+	return nil
+}
+
+// saveOffsets persists the read offsets of all followed files. The offset file is
+// replaced atomically, so that a crash never leaves a partially written file behind.
+func (t *fileTailer) saveOffsets() error {
+	offsetData, err := json.Marshal(t.offsets)
+	if err != nil {
+		return err
+	}
+	tempFile := t.conf.OffsetFile + ".tmp"
+	if err = os.WriteFile(tempFile, offsetData, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, t.conf.OffsetFile)
+}
+
+// fileFingerprint returns the hex encoded SHA-256 hash of the first length bytes
+// of the given file
+func fileFingerprint(file *os.File, length int) (string, error) {
+	buffer := make([]byte, length)
+	if _, err := file.ReadAt(buffer, 0); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	hash := sha256.Sum256(buffer)
+	return hex.EncodeToString(hash[:]), nil
+}
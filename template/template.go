@@ -17,6 +17,8 @@ import (
 	"time"
 
 	"github.com/wneessen/go-parsesyslog"
+
+	"github.com/wneessen/logranger/metadata"
 )
 
 // SHAAlgo is a enum-like type wrapper representing a SHA algorithm
@@ -41,8 +43,9 @@ type FuncMap struct{}
 // and output template.
 // It replaces special characters in the output template and creates a
 // new template, named "template", with custom template functions from
-// the FuncMap. It then populates a map with values from the LogMsg
-// and current time and executes the template using the map as the
+// the FuncMap. It then populates a map with values from the LogMsg,
+// the metadata attached to it by logranger (e.g. "tls_client_cn") and
+// the current time and executes the template using the map as the
 // data source. The compiled template result or an error is returned.
 func Compile(logMessage parsesyslog.LogMsg, matchGroup []string, outputTpl string) (string, error) {
 	procText := strings.Builder{}
@@ -57,15 +60,18 @@ func Compile(logMessage parsesyslog.LogMsg, matchGroup []string, outputTpl strin
 	}
 
 	dataMap := make(map[string]any)
+	for key, value := range metadata.Map(logMessage) {
+		dataMap[key] = value
+	}
 	dataMap["match"] = matchGroup
-	dataMap["hostname"] = logMessage.Hostname
+	dataMap["hostname"] = logMessage.Hostname()
 	dataMap["timestamp"] = logMessage.Timestamp
 	dataMap["now_rfc3339"] = time.Now().Format(time.RFC3339)
 	dataMap["now_unix"] = time.Now().Unix()
 	dataMap["severity"] = logMessage.Severity.String()
 	dataMap["facility"] = logMessage.Facility.String()
-	dataMap["appname"] = logMessage.AppName
-	dataMap["original_message"] = logMessage.Message
+	dataMap["appname"] = logMessage.AppName()
+	dataMap["original_message"] = logMessage.Message.String()
 
 	if err = tpl.Execute(&procText, dataMap); err != nil {
 		return procText.String(), fmt.Errorf("failed to compile template: %w", err)
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package template
+
+import (
+	"testing"
+
+	"github.com/wneessen/go-parsesyslog"
+
+	"github.com/wneessen/logranger/metadata"
+)
+
+func TestCompile(t *testing.T) {
+	logMessage := parsesyslog.LogMsg{
+		App:  []byte("sshd"),
+		Host: []byte("mymachine"),
+	}
+	logMessage.Message.WriteString("failed login for root")
+	metadata.Set(&logMessage, "tls_client_cn", "client.example.com")
+
+	tests := []struct {
+		name      string
+		outputTpl string
+		want      string
+		wantErr   bool
+	}{
+		{"hostname", "{{ .hostname }}", "mymachine", false},
+		{"app name", "{{ .appname }}", "sshd", false},
+		{"original message", "{{ .original_message }}", "failed login for root", false},
+		{"match group", "{{ index .match 1 }}", "root", false},
+		{"metadata", "{{ .tls_client_cn }}", "client.example.com", false},
+		{"template function", "{{ _ToUpper .hostname }}", "MYMACHINE", false},
+		{"escaped newline", `{{ .appname }}\n`, "sshd\n", false},
+		{"invalid template", "{{ .hostname", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Compile(logMessage, []string{"for root", "root"}, tt.outputTpl)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Compile returned error %v, want error: %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Compile = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
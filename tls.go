@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: 2023 Winni Neessen <wn@neessen.dev>
+//
+// SPDX-License-Identifier: MIT
+
+package logranger
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certReloader holds the X509 key pair of a TLS listener and reloads it when the
+// certificate or key file changes or when Reload is called, e.g. on SIGHUP. The
+// current certificate is served via GetCertificate, so that renewed certificates
+// are used for new connections without restarting the listener.
+type certReloader struct {
+	cert     *tls.Certificate
+	certPath string
+	keyPath  string
+	modTime  time.Time
+	mutex    sync.RWMutex
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// tlsListener wraps a TLS net.Listener with the certReloader of its certificate.
+// Closing the listener stops the certificate watcher.
+type tlsListener struct {
+	net.Listener
+	certs *certReloader
+}
+
+// newCertReloader returns a new certReloader with the key pair loaded from the
+// given certificate and key file.
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	reloader := &certReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		stop:     make(chan struct{}),
+	}
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Reload loads the X509 key pair from the certificate and key file. If the key pair
+// cannot be loaded, the previously loaded certificate stays active and an error is
+// returned.
+func (r *certReloader) Reload() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load X509 certificate: %w", err)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetCertificate satisfies the GetCertificate field of tls.Config and returns the
+// currently loaded certificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// Watch checks the certificate and key file for changes in the given interval and
+// reloads the key pair if any of the files has been modified. Errors during reload
+// are passed to the given error handler. Watch returns when Stop is called. An
+// interval of zero or less disables the watcher.
+func (r *certReloader) Watch(interval time.Duration, onError func(error)) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			modTime, err := r.filesModTime()
+			if err != nil {
+				onError(err)
+				continue
+			}
+			r.mutex.RLock()
+			changed := modTime.After(r.modTime)
+			r.mutex.RUnlock()
+			if !changed {
+				continue
+			}
+			if err = r.Reload(); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Stop stops the certificate watcher
+func (r *certReloader) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// filesModTime returns the latest modification time of the certificate and key file
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var modTime time.Time
+	for _, path := range []string{r.certPath, r.keyPath} {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return modTime, fmt.Errorf("failed to stat certificate file: %w", err)
+		}
+		if fileInfo.ModTime().After(modTime) {
+			modTime = fileInfo.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+// Close closes the TLS listener and stops the certificate watcher
+func (l *tlsListener) Close() error {
+	l.certs.Stop()
+	return l.Listener.Close()
+}
+
+// tlsVersionFromString returns the TLS version for the given version string
+// (e.g. "1.2"). An empty string returns TLS 1.2 as default minimum version.
+func tlsVersionFromString(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(version), "tls") {
+	case "1.2", "":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version: %s", version)
+	}
+}
+
+// cipherSuitesFromNames returns the IDs of the given TLS cipher suite names (e.g.
+// "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"). Only cipher suites without known
+// security issues are accepted. Cipher suites are not configurable for TLS 1.3.
+func cipherSuitesFromNames(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	cipherSuites := make([]uint16, 0, len(names))
+NameLoop:
+	for _, name := range names {
+		for _, cipherSuite := range tls.CipherSuites() {
+			if strings.EqualFold(cipherSuite.Name, name) {
+				cipherSuites = append(cipherSuites, cipherSuite.ID)
+				continue NameLoop
+			}
+		}
+		return nil, fmt.Errorf("unsupported or insecure TLS cipher suite: %s", name)
+	}
+	return cipherSuites, nil
+}
+
+// curvesFromNames returns the IDs of the given elliptic curve or key exchange names
+// in the given order of preference
+func curvesFromNames(names []string) ([]tls.CurveID, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	curves := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "x25519":
+			curves = append(curves, tls.X25519)
+		case "x25519mlkem768":
+			curves = append(curves, tls.X25519MLKEM768)
+		case "p256", "p-256", "secp256r1":
+			curves = append(curves, tls.CurveP256)
+		case "p384", "p-384", "secp384r1":
+			curves = append(curves, tls.CurveP384)
+		case "p521", "p-521", "secp521r1":
+			curves = append(curves, tls.CurveP521)
+		default:
+			return nil, fmt.Errorf("unsupported TLS curve: %s", name)
+		}
+	}
+	return curves, nil
+}